@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// shortest password we'll accept when registering
+	MIN_PASSWORD_LENGTH = 6
+	// most offline messages kept for someone before we stop taking more
+	MAX_TELLS = 50
+)
+
+var (
+	ErrAlreadyRegistered = errors.New("that username is already registered")
+	ErrNotRegistered     = errors.New("that username isn't registered, /register [password] first")
+	ErrShortPassword     = errors.New("passwords need to be at least 6 characters")
+	ErrMailboxFull       = errors.New("they have too many messages waiting already")
+)
+
+// a registered user, persisted so they can log back in and keep their
+// settings between sessions
+type Account struct {
+	Username string    `json:"username"`
+	Password string    `json:"password"`
+	Created  time.Time `json:"created"`
+
+	// usernames and user@host masks the user is ignoring
+	Ignores []string `json:"ignores,omitempty"`
+	// messages left with /tell while they were offline
+	Tells []Tell `json:"tells,omitempty"`
+}
+
+// a message left for someone to get when they next log in
+type Tell struct {
+	From string    `json:"from"`
+	Body string    `json:"body"`
+	Sent time.Time `json:"sent"`
+}
+
+// keeps track of every registered user
+type AccountStore struct {
+	accounts map[string]*Account
+	mtx      sync.Mutex
+
+	// where the store is saved, empty keeps it in memory only
+	path string
+}
+
+// store creation helper method
+func NewAccountStore() *AccountStore {
+	return &AccountStore{accounts: make(map[string]*Account)}
+}
+
+// loads accounts saved at path and persists any changes there from now on
+func (a *AccountStore) Load(path string) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	err := loadJSON(path, &a.accounts)
+	if err != nil {
+		return err
+	}
+	if a.accounts == nil {
+		a.accounts = make(map[string]*Account)
+	}
+	a.path = path
+	return nil
+}
+
+// writes the store to disk, expects mtx to be held
+func (a *AccountStore) save() error {
+	if a.path == "" {
+		return nil
+	}
+	return saveJSON(a.path, a.accounts)
+}
+
+func (a *AccountStore) Registered(username string) bool {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	_, ok := a.accounts[username]
+	return ok
+}
+
+func (a *AccountStore) Register(username, password string) error {
+	if len(password) < MIN_PASSWORD_LENGTH {
+		return ErrShortPassword
+	}
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if _, ok := a.accounts[username]; ok {
+		return ErrAlreadyRegistered
+	}
+	a.accounts[username] = &Account{Username: username, Password: hashed,
+		Created: time.Now()}
+	return a.save()
+}
+
+// whether password is right for a registered username
+func (a *AccountStore) Authenticate(username, password string) bool {
+	a.mtx.Lock()
+	account, ok := a.accounts[username]
+	a.mtx.Unlock()
+	return ok && checkPassword(account.Password, password)
+}
+
+func (a *AccountStore) Ignores(username string) []string {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	account, ok := a.accounts[username]
+	if !ok {
+		return nil
+	}
+	return append([]string(nil), account.Ignores...)
+}
+
+func (a *AccountStore) SetIgnores(username string, ignores []string) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	account, ok := a.accounts[username]
+	if !ok {
+		return ErrNotRegistered
+	}
+	account.Ignores = append([]string(nil), ignores...)
+	sort.Strings(account.Ignores)
+	return a.save()
+}
+
+// leaves a message for a registered user
+func (a *AccountStore) QueueTell(username string, tell Tell) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	account, ok := a.accounts[username]
+	if !ok {
+		return ErrNotRegistered
+	}
+	if len(account.Tells) >= MAX_TELLS {
+		return ErrMailboxFull
+	}
+	account.Tells = append(account.Tells, tell)
+	return a.save()
+}
+
+// hands over every message left for a user, they're gone from the store
+// afterwards
+func (a *AccountStore) TakeTells(username string) ([]Tell, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	account, ok := a.accounts[username]
+	if !ok || len(account.Tells) == 0 {
+		return nil, nil
+	}
+	tells := account.Tells
+	account.Tells = nil
+	return tells, a.save()
+}
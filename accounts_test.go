@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+func TestAccountsPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "accounts.json")
+
+	a := NewAccountStore()
+	if err := a.Load(path); err != nil {
+		t.Fatalf("unable to load missing store %v", err)
+	}
+	if err := a.Register("dan", "short"); err != ErrShortPassword {
+		t.Errorf("short password accepted %v", err)
+	}
+	if err := a.Register("dan", "hunter22"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Register("dan", "hunter22"); err != ErrAlreadyRegistered {
+		t.Errorf("registered twice %v", err)
+	}
+	a.SetIgnores("dan", []string{"jon", "*@10.0.0.*"})
+
+	reloaded := NewAccountStore()
+	if err := reloaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Authenticate("dan", "hunter22") ||
+		reloaded.Authenticate("dan", "wrong") {
+		t.Errorf("password not persisted")
+	}
+	if ignores := reloaded.Ignores("dan"); len(ignores) != 2 {
+		t.Errorf("ignores not persisted %v", ignores)
+	}
+}
+
+func TestIgnoreByHostMask(t *testing.T) {
+	_, _, s := createMocks()
+	sesh1 := createMockSession("dan")
+	sesh2 := createMockSession("jon")
+	sesh1.ignoreList["*@10.0.0.*"] = true
+	s.appendSession(sesh1)
+	s.appendSession(sesh2)
+
+	s.broadcast(session.NewMessage("test", testChannel, sesh2), MESSAGE)
+	if len(sesh1.messages) != 0 {
+		t.Errorf("message from ignored host delivered")
+	}
+
+	// ignoring a mask you match doesn't mean ignoring yourself
+	s.broadcast(session.NewMessage("test", testChannel, sesh1), MESSAGE)
+	if len(sesh1.messages) != 1 {
+		t.Errorf("own message not delivered")
+	}
+}
+
+func TestDirectMessages(t *testing.T) {
+	logger, _, s := createMocks()
+	sesh1 := createMockSession("dan")
+	sesh2 := createMockSession("jon")
+	s.appendSession(sesh1)
+	s.appendSession(sesh2)
+
+	if err := s.DirectMessage(sesh1, "jon", "psst"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sesh2.messages) != 1 || sesh2.messages[0].To != "jon" {
+		t.Errorf("direct message not delivered %v", sesh2.messages)
+	}
+	if len(logger.logs) != 0 {
+		t.Errorf("direct message written to chat log")
+	}
+
+	sesh2.ignoreList["dan"] = true
+	s.DirectMessage(sesh1, "jon", "psst")
+	if len(sesh2.messages) != 1 {
+		t.Errorf("direct message from ignored user delivered")
+	}
+
+	if err := s.DirectMessage(sesh1, "nobody", "psst"); err == nil {
+		t.Errorf("direct message to offline user didn't fail")
+	}
+}
+
+func TestTellDeliveredOnLogin(t *testing.T) {
+	_, _, s := createMocks()
+	dan := createMockSession("dan")
+	s.appendSession(dan)
+	s.accounts.Register("jon", "hunter22")
+
+	if _, err := s.Tell(dan, "nobody", "hello"); err == nil {
+		t.Errorf("message left for unregistered user")
+	}
+	delivered, err := s.Tell(dan, "jon", "standup moved to 10")
+	if err != nil || delivered {
+		t.Fatalf("message not queued %v %v", delivered, err)
+	}
+
+	jon := createMockSession("jon")
+	s.appendSession(jon)
+	found := false
+	for _, event := range jon.events {
+		if strings.Contains(event.Body, "standup moved to 10") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("queued message not delivered %v", jon.events)
+	}
+	if last := dan.events[len(dan.events)-1]; !strings.Contains(last.Body,
+		"jon got your message") {
+		t.Errorf("no delivery receipt %q", last.Body)
+	}
+	if tells, _ := s.accounts.TakeTells("jon"); len(tells) != 0 {
+		t.Errorf("delivered messages still queued %v", tells)
+	}
+}
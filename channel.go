@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// longest channel name we'll accept
+	MAX_CHANNEL_NAME_LENGTH = 32
+
+	// the modes operators can set, for help and errors
+	channelModes = "+i (invite only), +m (moderated), +k [password], " +
+		"+s (secret), +o [user] (operator)"
+)
+
+var (
+	ErrBadChannelName = errors.New("channel names must be 1-32 characters " +
+		"without spaces")
+	ErrNoSuchChannel = errors.New("no such channel")
+	ErrNotOperator   = errors.New("you need to be a channel operator to do that")
+	ErrUnknownMode   = errors.New("unknown mode, modes are " + channelModes)
+	ErrInviteOnly    = errors.New("channel is invite only, ask an operator to /invite you")
+	ErrBadPassword   = errors.New("wrong channel password, /join [channel] [password]")
+)
+
+// server side record of a channel, persisted so settings survive restarts
+type Channel struct {
+	Name    string    `json:"name"`
+	Topic   string    `json:"topic"`
+	Creator string    `json:"creator"`
+	Created time.Time `json:"created"`
+
+	// usernames allowed to change the channel's settings
+	Operators map[string]bool `json:"operators"`
+
+	// modes
+	InviteOnly bool `json:"invite_only"`
+	Moderated  bool `json:"moderated"`
+	Secret     bool `json:"secret"`
+	// hashed, see password.go. empty when the channel doesn't need one
+	Password string `json:"password,omitempty"`
+	// usernames let in past the invite only and password checks
+	Invited map[string]bool `json:"invited,omitempty"`
+
+	// moderation, see moderation.go
+	Bans []Ban `json:"bans,omitempty"`
+	// muted usernames and when the mute runs out, zero time never does
+	Muted map[string]time.Time `json:"muted,omitempty"`
+	// usernames allowed to speak while the channel is moderated
+	Voiced map[string]bool `json:"voiced,omitempty"`
+}
+
+// short summary of modes in the +ims style
+func (c *Channel) Modes() string {
+	modes := ""
+	if c.InviteOnly {
+		modes += "i"
+	}
+	if c.Password != "" {
+		modes += "k"
+	}
+	if c.Moderated {
+		modes += "m"
+	}
+	if c.Secret {
+		modes += "s"
+	}
+	if modes == "" {
+		return ""
+	}
+	return "+" + modes
+}
+
+// whether a user can change the channel's settings and moderate it
+func (c *Channel) IsOperator(username string) bool {
+	return c.Operators[username]
+}
+
+// invite only and password protected channels are private, they're kept out
+// of listings for anyone not in them
+func (c *Channel) Private() bool {
+	return c.InviteOnly || c.Password != ""
+}
+
+// checks whether a user may join the channel, operators and invited users
+// don't need a password
+func (c *Channel) CanJoin(username, password string) error {
+	if c.IsOperator(username) || c.Invited[username] {
+		return nil
+	}
+	if c.InviteOnly {
+		return ErrInviteOnly
+	}
+	if c.Password != "" && !checkPassword(c.Password, password) {
+		return ErrBadPassword
+	}
+	return nil
+}
+
+// anyone can set the topic of channels without operators (like the default
+// channel), otherwise it's left to operators
+func (c *Channel) CanSetTopic(username string) bool {
+	return len(c.Operators) == 0 || c.IsOperator(username)
+}
+
+// makes a copy safe to hand out while the registry keeps changing
+func (c *Channel) copy() Channel {
+	cp := *c
+	cp.Operators = copyBoolMap(c.Operators)
+	cp.Voiced = copyBoolMap(c.Voiced)
+	cp.Invited = copyBoolMap(c.Invited)
+	cp.Bans = append([]Ban(nil), c.Bans...)
+	cp.Muted = make(map[string]time.Time, len(c.Muted))
+	for username, until := range c.Muted {
+		cp.Muted[username] = until
+	}
+	return cp
+}
+
+func copyBoolMap(m map[string]bool) map[string]bool {
+	cp := make(map[string]bool, len(m))
+	for k, v := range m {
+		cp[k] = v
+	}
+	return cp
+}
+
+// cleans up user supplied channel names, "#ops" and "ops" are the same
+func normalizeChannelName(name string) (string, error) {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "#")
+	if len(name) == 0 || len(name) > MAX_CHANNEL_NAME_LENGTH ||
+		strings.ContainsAny(name, " \t\r\n") {
+		return "", ErrBadChannelName
+	}
+	return name, nil
+}
+
+// keeps track of every channel that has been created
+type ChannelRegistry struct {
+	channels map[string]*Channel
+	mtx      sync.Mutex
+
+	// where the registry is saved, empty keeps it in memory only
+	path string
+}
+
+// registry creation helper method
+func NewChannelRegistry() *ChannelRegistry {
+	return &ChannelRegistry{channels: make(map[string]*Channel)}
+}
+
+// loads channels saved at path and persists any changes there from now on
+func (r *ChannelRegistry) Load(path string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	err := loadJSON(path, &r.channels)
+	if err != nil {
+		return err
+	}
+	if r.channels == nil {
+		r.channels = make(map[string]*Channel)
+	}
+	r.path = path
+	return nil
+}
+
+// writes the registry to disk, expects mtx to be held
+func (r *ChannelRegistry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	return saveJSON(r.path, r.channels)
+}
+
+// looks up a channel by name
+func (r *ChannelRegistry) Get(name string) (Channel, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	channel, ok := r.channels[name]
+	if !ok {
+		return Channel{}, false
+	}
+	return channel.copy(), true
+}
+
+// returns a channel, creating it if it doesn't exist yet. whoever creates a
+// channel becomes its first operator
+func (r *ChannelRegistry) Ensure(name, creator string) (channel Channel,
+	created bool, err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if existing, ok := r.channels[name]; ok {
+		return existing.copy(), false, nil
+	}
+
+	c := &Channel{Name: name, Creator: creator, Created: time.Now(),
+		Operators: make(map[string]bool)}
+	if creator != "" {
+		c.Operators[creator] = true
+	}
+	r.channels[name] = c
+	return c.copy(), true, r.save()
+}
+
+// all channels sorted by name
+func (r *ChannelRegistry) List() (channels []Channel) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, channel := range r.channels {
+		channels = append(channels, channel.copy())
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
+	})
+	return channels
+}
+
+// applies a change to a channel on behalf of username, who must be an
+// operator
+func (r *ChannelRegistry) update(name, username string,
+	change func(*Channel) error) error {
+	return r.updateIf(name, username, (*Channel).IsOperator, change)
+}
+
+// applies a change to a channel on behalf of username if allowed says they
+// can
+func (r *ChannelRegistry) updateIf(name, username string,
+	allowed func(*Channel, string) bool, change func(*Channel) error) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	channel, ok := r.channels[name]
+	if !ok {
+		return ErrNoSuchChannel
+	}
+	if !allowed(channel, username) {
+		return ErrNotOperator
+	}
+
+	err := change(channel)
+	if err != nil {
+		return err
+	}
+	return r.save()
+}
+
+func (r *ChannelRegistry) SetTopic(name, username, topic string) error {
+	return r.updateIf(name, username, (*Channel).CanSetTopic,
+		func(c *Channel) error {
+			c.Topic = topic
+			return nil
+		})
+}
+
+// lets target into a channel past its invite only and password checks.
+// anyone can invite to password protected channels, only operators to invite
+// only ones
+func (r *ChannelRegistry) Invite(name, username, target string) error {
+	canInvite := func(c *Channel, username string) bool {
+		return !c.InviteOnly || c.IsOperator(username)
+	}
+	return r.updateIf(name, username, canInvite, func(c *Channel) error {
+		if c.Invited == nil {
+			c.Invited = make(map[string]bool)
+		}
+		c.Invited[target] = true
+		return nil
+	})
+}
+
+// takes back an invitation, on behalf of username who must be an operator
+func (r *ChannelRegistry) Uninvite(name, username, target string) error {
+	return r.update(name, username, func(c *Channel) error {
+		delete(c.Invited, target)
+		return nil
+	})
+}
+
+// changes a channel mode, mode is given as +x or -x with arg used for modes
+// that need one (+k password, +o user)
+func (r *ChannelRegistry) SetMode(name, username, mode, arg string) error {
+	if len(mode) != 2 || (mode[0] != '+' && mode[0] != '-') {
+		return ErrUnknownMode
+	}
+	on := mode[0] == '+'
+
+	return r.update(name, username, func(c *Channel) error {
+		switch mode[1] {
+		case 'i':
+			c.InviteOnly = on
+		case 'm':
+			c.Moderated = on
+		case 's':
+			c.Secret = on
+		case 'k':
+			if !on {
+				c.Password = ""
+				return nil
+			}
+			if arg == "" {
+				return errors.New("usage: /mode +k [password]")
+			}
+			hashed, err := hashPassword(arg)
+			if err != nil {
+				return err
+			}
+			c.Password = hashed
+		case 'o':
+			if arg == "" {
+				return errors.New("usage: /mode " + mode + " [user]")
+			}
+			if on {
+				c.Operators[arg] = true
+				return nil
+			}
+
+			// nobody could ever moderate the channel again, so don't let
+			// that happen by accident
+			if c.Operators[arg] && len(c.Operators) == 1 {
+				return errors.New("can't remove the last operator")
+			}
+			delete(c.Operators, arg)
+		default:
+			return ErrUnknownMode
+		}
+		return nil
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChannelCreatorIsOperator(t *testing.T) {
+	r := NewChannelRegistry()
+	r.Ensure("ops", "dan")
+
+	if err := r.SetTopic("ops", "jon", "hijacked"); err != ErrNotOperator {
+		t.Errorf("non operator allowed to set topic %v", err)
+	}
+	if err := r.SetTopic("ops", "dan", "deploys"); err != nil {
+		t.Errorf("operator not allowed to set topic %v", err)
+	}
+
+	channel, _ := r.Get("ops")
+	if channel.Topic != "deploys" {
+		t.Errorf("topic not set %s", channel.Topic)
+	}
+}
+
+func TestChannelModes(t *testing.T) {
+	r := NewChannelRegistry()
+	r.Ensure("ops", "dan")
+	r.SetMode("ops", "dan", "+i", "")
+	r.SetMode("ops", "dan", "+k", "secret")
+	r.SetMode("ops", "dan", "+s", "")
+
+	channel, _ := r.Get("ops")
+	if channel.Modes() != "+iks" {
+		t.Errorf("incorrect modes %s", channel.Modes())
+	}
+	if channel.Password == "secret" || !checkPassword(channel.Password, "secret") {
+		t.Errorf("password not hashed properly")
+	}
+
+	if err := r.SetMode("ops", "dan", "-o", "dan"); err == nil {
+		t.Errorf("last operator removed")
+	}
+	if err := r.SetMode("ops", "dan", "+z", ""); err != ErrUnknownMode {
+		t.Errorf("unknown mode accepted %v", err)
+	}
+}
+
+func TestChannelRegistryPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "channels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "channels.json")
+
+	r := NewChannelRegistry()
+	if err := r.Load(path); err != nil {
+		t.Fatalf("unable to load missing registry %v", err)
+	}
+	r.Ensure("ops", "dan")
+	r.SetTopic("ops", "dan", "deploys")
+
+	reloaded := NewChannelRegistry()
+	if err := reloaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	channel, ok := reloaded.Get("ops")
+	if !ok || channel.Topic != "deploys" || !channel.Operators["dan"] {
+		t.Errorf("channel not persisted %+v", channel)
+	}
+}
+
+func TestChannelNames(t *testing.T) {
+	if name, err := normalizeChannelName("#ops\r\n"); err != nil || name != "ops" {
+		t.Errorf("incorrect normalized name %q %v", name, err)
+	}
+	if _, err := normalizeChannelName("two words"); err == nil {
+		t.Errorf("bad channel name accepted")
+	}
+}
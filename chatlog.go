@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+const (
+	// written in the kind column for changes to earlier messages, the id
+	// column says which message changed
+	LOG_EDIT   = "edit"
+	LOG_DELETE = "delete"
+	// reactions being added and taken away, the body is the shortcode
+	LOG_REACT   = "react"
+	LOG_UNREACT = "unreact"
+
+	// records are a line each so the lines of multi-line messages are
+	// written with unit separators between them, they can't be typed
+	LOG_LINE_SEPARATOR = "\037"
+)
+
+// a single record read back from the chat log
+type logRecord struct {
+	T        time.Time
+	Channel  string
+	Username string
+	Kind     string
+	ID       uint64
+	ReplyTo  uint64
+	Body     string
+}
+
+// parses a line of the chat log. older logs don't have a kind, id or reply,
+// so records with fewer pieces are still understood
+func parseLogRecord(line string) (record logRecord, ok bool) {
+	pieces := strings.Split(line, RECORD_SEPARATOR)
+	if len(pieces) < 4 {
+		return record, false
+	}
+
+	nanos, err := strconv.ParseInt(pieces[0], 10, 64)
+	if err != nil {
+		return record, false
+	}
+	record.T = time.Unix(0, nanos)
+	record.Channel = pieces[1]
+	record.Username = pieces[2]
+
+	switch len(pieces) {
+	case 4:
+		record.Kind = string(session.KIND_NORMAL)
+		record.Body = pieces[3]
+	case 5:
+		record.Kind = pieces[3]
+		record.Body = pieces[4]
+	case 6:
+		record.Kind = pieces[3]
+		record.ID, err = strconv.ParseUint(pieces[4], 10, 64)
+		record.Body = pieces[5]
+	default:
+		record.Kind = pieces[3]
+		record.ID, err = strconv.ParseUint(pieces[4], 10, 64)
+		if err == nil {
+			record.ReplyTo, err = strconv.ParseUint(pieces[5], 10, 64)
+		}
+		record.Body = strings.Join(pieces[6:], RECORD_SEPARATOR)
+	}
+	if err != nil {
+		return record, false
+	}
+	record.Body = decodeLogBody(record.Body)
+	return record, true
+}
+
+// flattens a message body onto a single line for the chat log
+func encodeLogBody(body string) string {
+	body = strings.TrimRight(body, "\r\n")
+	return strings.NewReplacer("\r", "", "\n", LOG_LINE_SEPARATOR).Replace(body)
+}
+
+func decodeLogBody(body string) string {
+	return strings.Replace(body, LOG_LINE_SEPARATOR, "\n", -1)
+}
+
+// calls each for every record in the chat log at path, a missing log has no
+// records. lines that don't parse are skipped rather than guessed at
+func readChatLog(path string, each func(logRecord)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if record, ok := parseLogRecord(scanner.Text()); ok {
+			each(record)
+		}
+	}
+	return scanner.Err()
+}
+
+var (
+	// ensure loggedSession can stand in for a session
+	_ session.Session = (*loggedSession)(nil)
+)
+
+// stands in for whoever sent a message read back from the chat log, they may
+// not be online anymore so there's nobody to send anything to
+type loggedSession struct {
+	username string
+}
+
+func (l *loggedSession) Channel() string {
+	return ""
+}
+
+func (l *loggedSession) Channels() []string {
+	return nil
+}
+
+func (l *loggedSession) IgnoreList() map[string]bool {
+	return nil
+}
+
+func (l *loggedSession) Username() string {
+	return l.username
+}
+
+func (l *loggedSession) UsernameColor() string {
+	return ""
+}
+
+func (l *loggedSession) RemoteAddr() string {
+	return ""
+}
+
+func (l *loggedSession) Away() string {
+	return ""
+}
+
+func (l *loggedSession) LastActive() time.Time {
+	return time.Time{}
+}
+
+func (l *loggedSession) SendMessage(session.Message) error {
+	return nil
+}
+
+func (l *loggedSession) SendEvent(session.Message) error {
+	return nil
+}
+
+func (l *loggedSession) UpdateMessage(session.Message) error {
+	return nil
+}
+
+func (l *loggedSession) Kicked(string, string) error {
+	return nil
+}
+
+func (l *loggedSession) Close() error {
+	return nil
+}
+
+func (l *loggedSession) GetMessages(session.Host) (msg, event chan session.Message,
+	done chan error) {
+	return nil, nil, nil
+}
+
+// applies a chat log record to channel history, edits and deletions change
+// the message they refer to
+func (h *ChannelHistory) replay(record logRecord) {
+	// records from before messages had ids can't be referred to, keeping
+	// them would let anything aimed at id 0 hit all of them
+	if record.ID == 0 {
+		return
+	}
+
+	switch record.Kind {
+	case LOG_EDIT:
+		h.Update(record.ID, func(msg *session.Message) error {
+			msg.Body, msg.Edited = record.Body, true
+			msg.Links = session.FindLinks(record.Body)
+			return nil
+		})
+	case LOG_DELETE:
+		h.Update(record.ID, func(msg *session.Message) error {
+			msg.Body, msg.Deleted = "", true
+			return nil
+		})
+	case LOG_REACT, LOG_UNREACT:
+		h.Update(record.ID, func(msg *session.Message) error {
+			msg.Reactions = setReaction(msg.Reactions, record.Body,
+				record.Username, record.Kind == LOG_REACT)
+			return nil
+		})
+	default:
+		h.Record(session.Message{ID: record.ID, T: record.T,
+			From: &loggedSession{username: record.Username}, Body: record.Body,
+			Channel: record.Channel, Kind: session.MessageKind(record.Kind),
+			ReplyTo: record.ReplyTo, Links: session.FindLinks(record.Body)})
+	}
+}
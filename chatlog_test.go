@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+func TestParseLogRecord(t *testing.T) {
+	old, ok := parseLogRecord("100" + RECORD_SEPARATOR + "general" +
+		RECORD_SEPARATOR + "dan" + RECORD_SEPARATOR + "hello")
+	if !ok || old.Username != "dan" || old.Body != "hello" ||
+		old.Kind != string(session.KIND_NORMAL) {
+		t.Errorf("old record parsed incorrectly %+v", old)
+	}
+
+	edit, ok := parseLogRecord("100" + RECORD_SEPARATOR + "general" +
+		RECORD_SEPARATOR + "dan" + RECORD_SEPARATOR + LOG_EDIT +
+		RECORD_SEPARATOR + "42" + RECORD_SEPARATOR + "41" + RECORD_SEPARATOR +
+		"hello again")
+	if !ok || edit.ID != 42 || edit.ReplyTo != 41 || edit.Kind != LOG_EDIT ||
+		edit.Body != "hello again" {
+		t.Errorf("edit record parsed incorrectly %+v", edit)
+	}
+
+	if _, ok := parseLogRecord("continued from a previous line"); ok {
+		t.Errorf("partial record parsed")
+	}
+}
+
+func TestLegacyRecordsArentReferable(t *testing.T) {
+	_, _, s := createMocks()
+	dan := createMockSession("dan")
+	s.appendSession(dan)
+
+	// baseline logs don't carry ids
+	record, ok := parseLogRecord("100" + RECORD_SEPARATOR + testChannel +
+		RECORD_SEPARATOR + "dan" + RECORD_SEPARATOR + "old news")
+	if !ok || record.ID != 0 {
+		t.Fatalf("legacy record not parsed %+v", record)
+	}
+	s.history.replay(record)
+	if history := s.history.Recent(testChannel); len(history) != 0 {
+		t.Errorf("legacy record kept in history %v", history)
+	}
+
+	if _, err := s.Thread(dan, 0); err != ErrNoSuchMessage {
+		t.Errorf("thread shown for id 0 %v", err)
+	}
+	if err := s.React(dan, 0, "ack"); err != ErrNoSuchMessage {
+		t.Errorf("reacted to id 0 %v", err)
+	}
+}
+
+func TestLoadChatLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chatlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chat.log")
+
+	chatlog, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(chatlog, 10, []string{}, 1, testChannel)
+	dan := createMockSession("dan")
+	s.appendSession(dan)
+	first := session.NewMessage("morning\r\n", testChannel, dan)
+	first.T = time.Now().Add(-time.Hour)
+	s.broadcast(first, MESSAGE)
+	s.broadcast(session.NewMessage("later\nstill here\r\n", testChannel, dan),
+		MESSAGE)
+	s.React(dan, 2, "ack")
+	s.broadcast(session.NewMessage("bye\r\n", testChannel, dan), MESSAGE)
+	chatlog.Close()
+
+	restarted := NewServer(ioutil.Discard, 10, []string{}, 1, testChannel)
+	if err := restarted.LoadChatLog(path); err != nil {
+		t.Fatal(err)
+	}
+	if seen := restarted.seen.Get("dan"); seen.Sub(first.T) < time.Hour-time.Second {
+		t.Errorf("latest record not used for last seen %v", seen)
+	}
+	if id := restarted.nextMessageID(); id != 4 {
+		t.Errorf("message ids not carried on from the log %d", id)
+	}
+	history := restarted.history.Recent(testChannel)
+	if len(history) != 3 || history[0].From.Username() != "dan" ||
+		history[1].ID != 2 {
+		t.Fatalf("history not replayed from the log %v", history)
+	}
+	if history[1].Body != "later\nstill here" {
+		t.Errorf("multi-line message not replayed %q", history[1].Body)
+	}
+	if reactions := history[1].Reactions["ack"]; len(reactions) != 1 {
+		t.Errorf("reactions not replayed from the log %v", reactions)
+	}
+
+	// older times don't replace newer ones
+	restarted.seen.Mark("dan", first.T)
+	if restarted.seen.Get("dan").Equal(first.T) {
+		t.Errorf("older time replaced newer")
+	}
+}
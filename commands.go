@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+var (
+	ErrCommandExists   = errors.New("a command with that name is already registered")
+	ErrCommandReserved = errors.New("sessions handle that command themselves")
+	ErrBadCommandName  = errors.New("command names are a single word without the /")
+	// handlers return ErrUsage to have the command's usage shown instead
+	ErrUsage = errors.New("bad usage")
+)
+
+// carries out a command for caller. errors are meant to be shown to the
+// caller, anything else they should see is sent to them by the handler
+type CommandHandler func(s *Server, caller session.Session, args []string) error
+
+// a command the server handles itself, so it works the same for every kind of
+// session. plugins register their own with Server.RegisterCommand
+type Command struct {
+	// typed after the /
+	Name string
+	// arguments, like "[user] [message]"
+	Usage string
+	Help  string
+	// nil for commands sessions handle themselves, they're only listed
+	Handler CommandHandler
+}
+
+// keeps track of every command the server handles
+type CommandRegistry struct {
+	commands map[string]Command
+	mtx      sync.Mutex
+}
+
+// registry creation helper method
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{commands: make(map[string]Command)}
+}
+
+func (r *CommandRegistry) Register(cmd Command) error {
+	if cmd.Name == "" || strings.ContainsAny(cmd.Name, "/ \t\r\n") {
+		return ErrBadCommandName
+	}
+	if cmd.Handler == nil {
+		return errors.New("/" + cmd.Name + " needs a handler")
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if existing, ok := r.commands[cmd.Name]; ok {
+		if existing.Handler == nil {
+			return ErrCommandReserved
+		}
+		return ErrCommandExists
+	}
+	r.commands[cmd.Name] = cmd
+	return nil
+}
+
+// lists a command sessions handle themselves so it shows up in help and
+// nothing else can be registered under its name
+func (r *CommandRegistry) Reserve(info session.CommandInfo) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.commands[info.Name] = Command{Name: info.Name, Usage: info.Usage,
+		Help: info.Help}
+}
+
+func (r *CommandRegistry) Get(name string) (Command, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	cmd, ok := r.commands[name]
+	return cmd, ok
+}
+
+// all commands sorted by name
+func (r *CommandRegistry) List() (commands []Command) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, cmd := range r.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// adds a command to the server, sessions pass along any command they don't
+// handle themselves
+func (s *Server) RegisterCommand(cmd Command) error {
+	return s.commands.Register(cmd)
+}
+
+// runs a registered command for sesh, handled is false if there's no such
+// command. name may start with the /
+func (s *Server) RunCommand(sesh session.Session, name string,
+	args []string) (handled bool, err error) {
+	cmd, ok := s.commands.Get(strings.TrimPrefix(name, "/"))
+	if !ok || cmd.Handler == nil {
+		return false, nil
+	}
+
+	// sessions split on single spaces, so there can be empty arguments
+	var cleaned []string
+	for _, arg := range args {
+		if arg = strings.TrimSpace(arg); arg != "" {
+			cleaned = append(cleaned, arg)
+		}
+	}
+
+	err = cmd.Handler(s, sesh, cleaned)
+	if err == ErrUsage {
+		return true, errors.New("usage: /" + cmd.Name + " " + cmd.Usage)
+	}
+	return true, err
+}
+
+// every registered and reserved command, for sessions to build their help
+// from
+func (s *Server) Commands() (commands []session.CommandInfo) {
+	for _, cmd := range s.commands.List() {
+		commands = append(commands, session.CommandInfo{Name: cmd.Name,
+			Usage: cmd.Usage, Help: cmd.Help})
+	}
+	return commands
+}
+
+// commands every server starts out with
+func builtinCommands() []Command {
+	commands := []Command{
+		{Name: "who", Help: "lists who's in the channel, marking anyone " +
+			"away or idle", Handler: whoCommand},
+		{Name: "list", Help: "lists channels", Handler: listCommand},
+		{Name: "topic", Usage: "[topic]",
+			Help: "shows or sets the channel topic", Handler: topicCommand},
+		{Name: "mode", Usage: "[+/-mode] [arg]",
+			Help:    "shows or sets channel modes, " + channelModes,
+			Handler: modeCommand},
+		{Name: "msg", Usage: "[user] [message]",
+			Help: "sends someone a direct message", Handler: msgCommand},
+		{Name: "register", Usage: "[password]",
+			Help: "registers your username, registered usernames need the " +
+				"password to log in and keep their ignore list",
+			Handler: registerCommand},
+		{Name: "tell", Usage: "[user] [message]",
+			Help: "leaves a message for a registered user to get when " +
+				"they next log in, online users get it straight away",
+			Handler: tellCommand},
+		{Name: "invite", Usage: "[user] [channel]",
+			Help: "lets someone into a private channel, only operators " +
+				"can invite to +i channels",
+			Handler: inviteCommand},
+		{Name: "react", Usage: "[message id] [+1|ack|eyes|...]",
+			Help:    "reacts to a message, reacting again takes it back",
+			Handler: reactCommand},
+	}
+
+	for _, action := range moderationActions {
+		action := action
+		commands = append(commands, Command{Name: action,
+			Usage: moderationUsage[action],
+			Help:  moderationDescriptions[action] + " (operators)",
+			Handler: func(s *Server, caller session.Session,
+				args []string) error {
+				return s.Moderate(caller, caller.Channel(), action, args)
+			}})
+	}
+	return commands
+}
+
+// handles /tell [user] [message]
+func tellCommand(s *Server, caller session.Session, args []string) error {
+	if len(args) < 2 {
+		return ErrUsage
+	}
+	to, body := args[0], strings.Join(args[1:], " ")
+
+	delivered, err := s.Tell(caller, to, body)
+	if err != nil {
+		return errors.New("unable to leave a message for " + to + ": " +
+			err.Error())
+	}
+	if !delivered {
+		return caller.SendEvent(systemEvent(to+" is offline, "+
+			"they'll get your message when they next log in",
+			caller.Channel(), caller))
+	}
+
+	// online, so it went out like /msg and the sender gets their copy
+	msg := session.NewMessage(body, caller.Channel(), caller)
+	msg.To, msg.Kind = to, session.KIND_DM
+	return caller.SendMessage(msg)
+}
+
+// handles /invite [user] [channel], defaulting to the current channel
+func inviteCommand(s *Server, caller session.Session, args []string) error {
+	if len(args) < 1 {
+		return ErrUsage
+	}
+	channel := caller.Channel()
+	if len(args) > 1 {
+		channel = strings.TrimPrefix(args[1], "#")
+	}
+
+	err := s.Invite(caller, args[0], channel)
+	if err != nil {
+		return errors.New("unable to invite to #" + channel + ": " +
+			err.Error())
+	}
+	return nil
+}
+
+// handles /react [message id] [shortcode]
+func reactCommand(s *Server, caller session.Session, args []string) error {
+	if len(args) != 2 {
+		return ErrUsage
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return ErrUsage
+	}
+
+	err = s.React(caller, id, args[1])
+	if err != nil {
+		return errors.New("unable to react: " + err.Error())
+	}
+	return nil
+}
+
+// handles /who, listing everyone in the current channel
+func whoCommand(s *Server, caller session.Session, args []string) error {
+	channel := caller.Channel()
+	err := caller.SendEvent(systemEvent(session.DescribeChannel(
+		s.ChannelInfo(channel)), channel, caller))
+	if err != nil {
+		return err
+	}
+
+	for _, member := range s.ChannelMembers(channel) {
+		err = caller.SendEvent(systemEvent("  "+member.Username()+
+			session.Presence(member), channel, caller))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handles /list
+func listCommand(s *Server, caller session.Session, args []string) error {
+	channels := s.ListChannels(caller)
+	if len(channels) == 0 {
+		return caller.SendEvent(systemEvent("no channels", caller.Channel(),
+			caller))
+	}
+
+	for _, info := range channels {
+		err := caller.SendEvent(systemEvent(session.DescribeChannel(info),
+			caller.Channel(), caller))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handles /topic [topic], with no topic the current one is shown
+func topicCommand(s *Server, caller session.Session, args []string) error {
+	channel := caller.Channel()
+	if len(args) == 0 {
+		topic := s.ChannelInfo(channel).Topic
+		if topic == "" {
+			topic = "no topic is set"
+		}
+		return caller.SendEvent(systemEvent("#"+channel+": "+topic, channel,
+			caller))
+	}
+	return s.SetTopic(caller, channel, strings.Join(args, " "))
+}
+
+// handles /mode [+/-mode] [arg], with no mode the current ones are shown
+func modeCommand(s *Server, caller session.Session, args []string) error {
+	channel := caller.Channel()
+	if len(args) == 0 {
+		modes := s.ChannelInfo(channel).Modes
+		if modes == "" {
+			modes = "no modes set"
+		}
+		return caller.SendEvent(systemEvent("#"+channel+": "+modes+
+			", modes are "+channelModes, channel, caller))
+	}
+
+	arg := ""
+	if len(args) > 1 {
+		arg = args[1]
+	}
+	return s.SetChannelMode(caller, channel, args[0], arg)
+}
+
+// handles /msg [user] [message], the message is shown to the sender as well
+// so there's a record of it on both sides
+func msgCommand(s *Server, caller session.Session, args []string) error {
+	if len(args) < 2 {
+		return ErrUsage
+	}
+	to, body := args[0], strings.Join(args[1:], " ")
+
+	err := s.DirectMessage(caller, to, body)
+	if err != nil {
+		return errors.New("unable to message " + to + ": " + err.Error())
+	}
+
+	msg := session.NewMessage(body, caller.Channel(), caller)
+	msg.To, msg.Kind = to, session.KIND_DM
+	return caller.SendMessage(msg)
+}
+
+// handles /register [password]
+func registerCommand(s *Server, caller session.Session, args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+
+	err := s.Register(caller, args[0])
+	if err != nil {
+		return errors.New("unable to register: " + err.Error())
+	}
+	return caller.SendEvent(systemEvent(caller.Username()+" is now registered, "+
+		"you'll be asked for your password next time", caller.Channel(), caller))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+func TestCommandRegistry(t *testing.T) {
+	_, sesh, s := createMocks()
+	s.appendSession(sesh)
+
+	var ran []string
+	oncall := Command{Name: "oncall", Usage: "[team]", Help: "who's on call",
+		Handler: func(s *Server, caller session.Session, args []string) error {
+			if len(args) != 1 {
+				return ErrUsage
+			}
+			ran = append(ran, caller.Username()+" "+args[0])
+			return caller.SendEvent(session.NewMessage("alice is on call",
+				caller.Channel(), caller))
+		}}
+	if err := s.RegisterCommand(oncall); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterCommand(oncall); err != ErrCommandExists {
+		t.Errorf("command registered twice %v", err)
+	}
+	if err := s.RegisterCommand(Command{Name: "/bad name"}); err != ErrBadCommandName {
+		t.Errorf("bad command name accepted %v", err)
+	}
+
+	// sessions would never pass these along
+	oncall.Name = "join"
+	if err := s.RegisterCommand(oncall); err != ErrCommandReserved {
+		t.Errorf("session command shadowed %v", err)
+	}
+	if handled, _ := s.RunCommand(sesh, "/join", []string{"ops"}); handled {
+		t.Errorf("session command run by the server")
+	}
+	oncall.Name = "who"
+	if err := s.RegisterCommand(oncall); err != ErrCommandExists {
+		t.Errorf("builtin command replaced %v", err)
+	}
+	oncall.Name = "oncall"
+
+	// arguments are cleaned up for handlers
+	handled, err := s.RunCommand(sesh, "/oncall", []string{"", "ops\r\n"})
+	if !handled || err != nil || len(ran) != 1 || ran[0] != "testuser ops" {
+		t.Errorf("command not run %v %v", err, ran)
+	}
+	if _, err := s.RunCommand(sesh, "oncall", nil); err == nil ||
+		err.Error() != "usage: /oncall [team]" {
+		t.Errorf("usage not shown %v", err)
+	}
+	if handled, _ := s.RunCommand(sesh, "/nope", nil); handled {
+		t.Errorf("unknown command handled")
+	}
+
+	var names []string
+	for _, cmd := range s.Commands() {
+		names = append(names, cmd.Name)
+	}
+	if listed := strings.Join(names, " "); !strings.Contains(listed, "kick") ||
+		!strings.Contains(listed, "oncall") || !strings.Contains(listed, "join") {
+		t.Errorf("commands not listed %s", listed)
+	}
+}
+
+func TestBuiltinCommands(t *testing.T) {
+	_, _, s := createMocks()
+	dan := createMockSession("dan")
+	s.appendSession(dan)
+
+	if _, err := s.RunCommand(dan, "/kick", nil); err == nil ||
+		err.Error() != "usage: /kick [user] [reason]" {
+		t.Errorf("moderation usage not shown %v", err)
+	}
+	if _, err := s.RunCommand(dan, "/kick", []string{"jon"}); !errors.Is(err,
+		ErrNotOperator) {
+		t.Errorf("non operator kicked %v", err)
+	}
+
+	s.broadcast(session.NewMessage("hi", testChannel, dan), MESSAGE)
+	if _, err := s.RunCommand(dan, "/react", []string{"1", "+1"}); err != nil {
+		t.Fatal(err)
+	}
+	if last := dan.updates[len(dan.updates)-1]; len(last.Reactions["+1"]) != 1 {
+		t.Errorf("reaction not added %v", last.Reactions)
+	}
+
+	jon := createMockSession("jon")
+	s.appendSession(jon)
+	if _, err := s.RunCommand(dan, "/msg", []string{"jon"}); err == nil ||
+		err.Error() != "usage: /msg [user] [message]" {
+		t.Errorf("msg usage not shown %v", err)
+	}
+	if _, err := s.RunCommand(dan, "/msg", []string{"jon", "hello", "there"}); err != nil {
+		t.Fatal(err)
+	}
+	if last := jon.messages[len(jon.messages)-1]; last.Body != "hello there" ||
+		last.Kind != session.KIND_DM {
+		t.Errorf("direct message not delivered %+v", last)
+	}
+	if last := dan.messages[len(dan.messages)-1]; last.Body != "hello there" ||
+		last.To != "jon" {
+		t.Errorf("sender didn't get their copy %+v", last)
+	}
+
+	jon.away = "lunch"
+	dan.events = nil
+	if _, err := s.RunCommand(dan, "/who", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(dan.events) != 3 || !strings.HasPrefix(dan.events[0].Body, "#"+testChannel) ||
+		dan.events[2].Body != "  jon [away: lunch]" {
+		t.Errorf("incorrect /who %v", dan.events)
+	}
+
+	if _, err := s.RunCommand(dan, "/topic", []string{"release", "day"}); err != nil {
+		t.Fatal(err)
+	}
+	if topic := s.ChannelInfo(testChannel).Topic; topic != "release day" {
+		t.Errorf("topic not set %q", topic)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+var (
+	ErrNotYourMessage = errors.New("you can only change your own messages")
+)
+
+// changes the body of a message someone sent earlier, everyone who can see
+// the message gets the new version
+func (s *Server) EditMessage(sesh session.Session, id uint64, body string) error {
+	if len(strings.TrimSpace(body)) < s.minimumMessageSize {
+		return errors.New("message too short")
+	}
+
+	msg, err := s.history.Update(id, func(msg *session.Message) error {
+		if msg.Deleted {
+			return ErrNoSuchMessage
+		}
+		if msg.From.Username() != sesh.Username() {
+			return ErrNotYourMessage
+		}
+
+		// muting someone shouldn't leave them able to rewrite what they
+		// already said
+		channel, ok := s.channels.Get(msg.Channel)
+		if ok && !channel.CanSpeak(sesh.Username(), time.Now()) {
+			return errors.New("you can't speak in #" + msg.Channel + " right now")
+		}
+
+		msg.Body = body
+		msg.Edited = true
+		msg.Links = session.FindLinks(body)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logChange(msg, LOG_EDIT)
+	s.broadcast(msg, UPDATE)
+	s.previewLinks(msg)
+	return nil
+}
+
+// a message and its replies, for people in the message's channel
+func (s *Server) Thread(sesh session.Session, id uint64) ([]session.Message,
+	error) {
+	if id == 0 {
+		return nil, ErrNoSuchMessage
+	}
+	thread, err := s.history.Thread(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(thread) == 0 || !isMember(sesh, thread[0].Channel) {
+		return nil, ErrNoSuchMessage
+	}
+	return thread, nil
+}
+
+// removes a message, leaving a tombstone behind so everyone can see
+// something was there. operators can delete anyone's messages
+func (s *Server) DeleteMessage(sesh session.Session, id uint64) error {
+	msg, err := s.history.Update(id, func(msg *session.Message) error {
+		if msg.Deleted {
+			return ErrNoSuchMessage
+		}
+		if msg.From.Username() != sesh.Username() {
+			channel, ok := s.channels.Get(msg.Channel)
+			if !ok || !channel.IsOperator(sesh.Username()) {
+				return ErrNotYourMessage
+			}
+		}
+
+		msg.Body = ""
+		msg.Deleted = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logChange(msg, LOG_DELETE)
+	s.broadcast(msg, UPDATE)
+	return nil
+}
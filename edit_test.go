@@ -0,0 +1,179 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+func TestEditAndDeleteMessages(t *testing.T) {
+	logger, _, s := createMocks()
+	dan := createMockSession("dan")
+	jon := createMockSession("jon")
+	s.appendSession(dan)
+	s.appendSession(jon)
+
+	s.broadcast(session.NewMessage("helo", testChannel, dan), MESSAGE)
+	id := jon.messages[0].ID
+	if id == 0 {
+		t.Fatalf("message not given an id")
+	}
+
+	if err := s.EditMessage(jon, id, "hijacked"); err != ErrNotYourMessage {
+		t.Errorf("edited someone else's message %v", err)
+	}
+	if err := s.EditMessage(dan, id, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if len(jon.updates) != 1 || jon.updates[0].Body != "hello" ||
+		!jon.updates[0].Edited {
+		t.Errorf("edit not sent to channel %v", jon.updates)
+	}
+
+	if err := s.DeleteMessage(dan, id); err != nil {
+		t.Fatal(err)
+	}
+	if last := jon.updates[len(jon.updates)-1]; !last.Deleted || last.Body != "" {
+		t.Errorf("deletion not sent to channel %+v", last)
+	}
+	if err := s.EditMessage(dan, id, "back again"); err != ErrNoSuchMessage {
+		t.Errorf("deleted message edited %v", err)
+	}
+
+	// the original, the revision and the tombstone
+	if len(logger.logs) != 3 {
+		t.Errorf("revisions not logged %d", len(logger.logs))
+	}
+}
+
+func TestRepliesAndThreads(t *testing.T) {
+	_, _, s := createMocks()
+	dan := createMockSession("dan")
+	jon := createMockSession("jon")
+	jon.extraChannels = []string{"ops"}
+	s.appendSession(dan)
+	s.appendSession(jon)
+
+	s.broadcast(session.NewMessage("lunch?", testChannel, dan), MESSAGE)
+	root := dan.messages[0].ID
+
+	reply := session.NewMessage("sure", testChannel, jon)
+	reply.ReplyTo = root
+	s.broadcast(reply, MESSAGE)
+	s.broadcast(session.NewMessage("unrelated", testChannel, jon), MESSAGE)
+
+	// replies can't reach into other channels
+	elsewhere := session.NewMessage("leak", "ops", jon)
+	elsewhere.ReplyTo = root
+	s.broadcast(elsewhere, MESSAGE)
+	if last := jon.messages[len(jon.messages)-1]; last.ReplyTo != 0 {
+		t.Errorf("reply crossed channels")
+	}
+
+	nested := session.NewMessage("where?", testChannel, dan)
+	nested.ReplyTo = dan.messages[1].ID
+	s.broadcast(nested, MESSAGE)
+
+	// asking about a reply shows the whole thread
+	thread, err := s.Thread(dan, dan.messages[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread) != 3 || thread[0].ID != root || thread[2].Body != "where?" {
+		t.Errorf("incorrect thread %v", thread)
+	}
+
+	outsider := createMockSession("eve")
+	outsider.extraChannels = nil
+	s.broadcast(session.NewMessage("secret", "ops", jon), MESSAGE)
+	if _, err := s.Thread(outsider, jon.messages[len(jon.messages)-1].ID); err == nil {
+		t.Errorf("thread shown to non member")
+	}
+}
+
+func TestRepliesCantLoop(t *testing.T) {
+	_, _, s := createMocks()
+	dan := createMockSession("dan")
+	s.appendSession(dan)
+
+	// replying to the id the message itself is about to get
+	self := session.NewMessage("me?", testChannel, dan)
+	self.ReplyTo = s.lastMessageID + 1
+	s.broadcast(self, MESSAGE)
+	if last := dan.messages[len(dan.messages)-1]; last.ReplyTo != 0 {
+		t.Errorf("message replied to itself")
+	}
+	ahead := session.NewMessage("later", testChannel, dan)
+	ahead.ReplyTo = s.lastMessageID + 5
+	s.broadcast(ahead, MESSAGE)
+	if last := dan.messages[len(dan.messages)-1]; last.ReplyTo != 0 {
+		t.Errorf("reply to a message that doesn't exist yet")
+	}
+
+	// loops can still come from logs, threads have to stop walking them
+	history := NewChannelHistory(10)
+	for _, msg := range []session.Message{{ID: 1, ReplyTo: 1},
+		{ID: 2, ReplyTo: 3}, {ID: 3, ReplyTo: 2}} {
+		msg.Channel = testChannel
+		history.Record(msg)
+	}
+	done := make(chan bool)
+	go func() {
+		history.Thread(1)
+		history.Thread(2)
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("thread walk never finished")
+	}
+}
+
+func TestReactions(t *testing.T) {
+	logger, _, s := createMocks()
+	dan := createMockSession("dan")
+	jon := createMockSession("jon")
+	s.appendSession(dan)
+	s.appendSession(jon)
+
+	s.broadcast(session.NewMessage("ship it?", testChannel, dan), MESSAGE)
+	id := dan.messages[0].ID
+
+	if err := s.React(jon, id, "+1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.React(dan, id, "+1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.React(dan, id, "EYES"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.React(jon, id, "no spaces"); err != ErrBadReaction {
+		t.Errorf("bad shortcode accepted %v", err)
+	}
+
+	last := jon.updates[len(jon.updates)-1]
+	if len(last.Reactions["+1"]) != 2 || len(last.Reactions["eyes"]) != 1 {
+		t.Errorf("reactions not tallied %v", last.Reactions)
+	}
+	// earlier updates are left alone as reactions change
+	if len(jon.updates[0].Reactions["+1"]) != 1 {
+		t.Errorf("earlier update changed %v", jon.updates[0].Reactions)
+	}
+
+	// reacting again takes it back
+	if err := s.React(dan, id, "eyes"); err != nil {
+		t.Fatal(err)
+	}
+	last = jon.updates[len(jon.updates)-1]
+	if _, ok := last.Reactions["eyes"]; ok {
+		t.Errorf("reaction not taken back %v", last.Reactions)
+	}
+
+	// the message and every reaction
+	if len(logger.logs) != 5 {
+		t.Errorf("reactions not logged %d", len(logger.logs))
+	}
+}
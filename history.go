@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+var (
+	ErrNoSuchMessage = errors.New("no such message, only recent messages " +
+		"can be changed")
+)
+
+// recent messages for each channel, kept in memory so people joining a
+// channel can catch up on what was said before they got there
+type ChannelHistory struct {
+	messages map[string][]session.Message
+	size     int
+	mtx      sync.Mutex
+}
+
+// history creation helper method, size is how many messages are kept per
+// channel
+func NewChannelHistory(size int) *ChannelHistory {
+	return &ChannelHistory{messages: make(map[string][]session.Message),
+		size: size}
+}
+
+// adds a message to its channel's history, dropping the oldest once full
+func (h *ChannelHistory) Record(msg session.Message) {
+	if h.size <= 0 {
+		return
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	messages := append(h.messages[msg.Channel], msg)
+	if len(messages) > h.size {
+		messages = messages[len(messages)-h.size:]
+	}
+	h.messages[msg.Channel] = messages
+}
+
+// recent messages for a channel, oldest first. callers are responsible for
+// checking whoever is asking is allowed to see them
+func (h *ChannelHistory) Recent(channel string) []session.Message {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return append([]session.Message(nil), h.messages[channel]...)
+}
+
+// applies a change to a message still in history, returning the message as
+// it is afterwards. change can refuse by returning an error
+func (h *ChannelHistory) Update(id uint64,
+	change func(*session.Message) error) (session.Message, error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for _, messages := range h.messages {
+		for i := range messages {
+			if messages[i].ID != id {
+				continue
+			}
+			err := change(&messages[i])
+			if err != nil {
+				return session.Message{}, err
+			}
+			return messages[i], nil
+		}
+	}
+	return session.Message{}, ErrNoSuchMessage
+}
+
+// finds a message still in history
+func (h *ChannelHistory) Find(id uint64) (session.Message, bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for _, messages := range h.messages {
+		for _, msg := range messages {
+			if msg.ID == id {
+				return msg, true
+			}
+		}
+	}
+	return session.Message{}, false
+}
+
+// a message and every reply to it (and replies to those), oldest first. the
+// thread starts from the top even when asked about a reply
+func (h *ChannelHistory) Thread(id uint64) ([]session.Message, error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	var messages []session.Message
+	for _, channelMessages := range h.messages {
+		for _, msg := range channelMessages {
+			if msg.ID == id {
+				messages = channelMessages
+			}
+		}
+	}
+	if messages == nil {
+		return nil, ErrNoSuchMessage
+	}
+
+	parents := make(map[uint64]uint64, len(messages))
+	for _, msg := range messages {
+		parents[msg.ID] = msg.ReplyTo
+	}
+
+	// walk up to the first message we still have. replies read back from
+	// old logs may loop, so stop at anything we've already seen
+	root := id
+	visited := map[uint64]bool{root: true}
+	for parents[root] != 0 && !visited[parents[root]] {
+		if _, ok := parents[parents[root]]; !ok {
+			break
+		}
+		root = parents[root]
+		visited[root] = true
+	}
+
+	inThread := func(id uint64) bool {
+		visited := make(map[uint64]bool)
+		for ; id != 0 && !visited[id]; id = parents[id] {
+			if id == root {
+				return true
+			}
+			visited[id] = true
+		}
+		return false
+	}
+
+	var thread []session.Message
+	for _, msg := range messages {
+		if inThread(msg.ID) {
+			thread = append(thread, msg)
+		}
+	}
+	return thread, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+const (
+	// largest request body an incoming webhook will read
+	MAX_INCOMING_PAYLOAD_SIZE = 64 * 1024
+	// longest message an incoming webhook can post
+	MAX_INCOMING_BODY_LENGTH = 4096
+
+	// incoming webhooks post to INCOMING_WEBHOOK_PATH + channel name
+	INCOMING_WEBHOOK_PATH = "/hooks/"
+)
+
+// lets another tool post into a channel, configured in
+// incoming_webhooks_file. requests need the token as a bearer token
+type IncomingWebhook struct {
+	Channel string `json:"channel"`
+	Token   string `json:"token"`
+	// who messages show up as being from
+	Name string `json:"name"`
+}
+
+// what incoming webhooks post
+type incomingPayload struct {
+	Body string `json:"body"`
+	// normal by default, notices are drawn so they aren't mistaken for
+	// someone talking
+	Kind session.MessageKind `json:"kind,omitempty"`
+}
+
+// loads incoming webhooks from path, a missing file means there aren't any
+func (s *Server) LoadIncomingWebhooks(path string) error {
+	var hooks []IncomingWebhook
+	err := loadJSON(path, &hooks)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if hook.Channel == "" || hook.Token == "" || hook.Name == "" {
+			return errors.New("incoming webhooks need a channel, token and name")
+		}
+	}
+
+	s.incomingMtx.Lock()
+	s.incoming = hooks
+	s.incomingMtx.Unlock()
+	return nil
+}
+
+// finds the incoming webhook for a channel with the given token
+func (s *Server) incomingWebhook(channel, token string) (IncomingWebhook, bool) {
+	s.incomingMtx.Lock()
+	defer s.incomingMtx.Unlock()
+	for _, hook := range s.incoming {
+		if hook.Channel == channel &&
+			subtle.ConstantTimeCompare([]byte(hook.Token), []byte(token)) == 1 {
+			return hook, true
+		}
+	}
+	return IncomingWebhook{}, false
+}
+
+// keeps to the same printable ascii telnet users are held to, lines are
+// kept so posts can be multi-line
+func cleanIncomingBody(body string) string {
+	body = strings.Replace(body, "\r\n", "\n", -1)
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '\n' || r >= 32 && r <= 126 {
+			return r
+		}
+		return -1
+	}, body))
+}
+
+// whether an incoming webhook posts as username, nobody else gets to use it
+func (s *Server) incomingName(username string) bool {
+	s.incomingMtx.Lock()
+	defer s.incomingMtx.Unlock()
+	for _, hook := range s.incoming {
+		if hook.Name == username {
+			return true
+		}
+	}
+	return false
+}
+
+// handles posts to incoming webhooks
+func (s *Server) handleIncomingWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "incoming webhooks only take POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	channel := strings.TrimPrefix(r.URL.Path, INCOMING_WEBHOOK_PATH)
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	hook, ok := s.incomingWebhook(channel, token)
+	if !ok {
+		http.Error(w, "unknown channel or token", http.StatusUnauthorized)
+		return
+	}
+
+	var payload incomingPayload
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body,
+		MAX_INCOMING_PAYLOAD_SIZE)).Decode(&payload)
+	if err != nil {
+		http.Error(w, "payload isn't json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	body := cleanIncomingBody(payload.Body)
+	if len(body) < s.minimumMessageSize || len(body) > MAX_INCOMING_BODY_LENGTH {
+		http.Error(w, "body is too short or too long", http.StatusBadRequest)
+		return
+	}
+	switch payload.Kind {
+	case "":
+		payload.Kind = session.KIND_NORMAL
+	case session.KIND_NORMAL, session.KIND_NOTICE, session.KIND_ACTION:
+	default:
+		http.Error(w, "kind must be normal, notice or action", http.StatusBadRequest)
+		return
+	}
+
+	// broadcast would drop the message without saying why
+	info, ok := s.channels.Get(channel)
+	if !ok {
+		http.Error(w, ErrNoSuchChannel.Error(), http.StatusNotFound)
+		return
+	}
+	if !info.CanSpeak(hook.Name, time.Now()) {
+		http.Error(w, hook.Name+" can't speak in #"+channel, http.StatusForbidden)
+		return
+	}
+
+	// like messages read back from the log there's nobody to send anything
+	// back to
+	msg := session.NewMessage(body, channel, &loggedSession{username: hook.Name})
+	msg.Kind = payload.Kind
+	s.broadcast(msg, MESSAGE)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serves incoming webhooks over http on addr
+func (s *Server) ListenHTTP(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(INCOMING_WEBHOOK_PATH, s.handleIncomingWebhook)
+	return http.ListenAndServe(addr, mux)
+}
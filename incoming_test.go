@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+func TestIncomingWebhooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "incoming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "incoming.json")
+	err = saveJSON(path, []IncomingWebhook{{Channel: testChannel,
+		Token: "s3cret", Name: "ci"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger, sesh, s := createMocks()
+	s.appendSession(sesh)
+	if err := s.LoadIncomingWebhooks(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// nobody can log in or add a bot as the webhook
+	if s.UsernameAvailable("ci") {
+		t.Errorf("incoming webhook name available to log in with")
+	}
+	if err := s.AddBot(s.NewBot("ci")); err == nil {
+		t.Errorf("bot added with an incoming webhook name")
+	}
+	ts := httptest.NewServer(http.HandlerFunc(s.handleIncomingWebhook))
+	defer ts.Close()
+
+	post := func(channel, token, payload string) int {
+		req, _ := http.NewRequest("POST", ts.URL+INCOMING_WEBHOOK_PATH+channel,
+			strings.NewReader(payload))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post(testChannel, "wrong", `{"body": "hi"}`); code != http.StatusUnauthorized {
+		t.Errorf("bad token accepted %d", code)
+	}
+	if code := post("other", "s3cret", `{"body": "hi"}`); code != http.StatusUnauthorized {
+		t.Errorf("token used for another channel %d", code)
+	}
+	if code := post(testChannel, "s3cret", `{"body": " \u001b\u0007 "}`); code != http.StatusBadRequest {
+		t.Errorf("empty body accepted %d", code)
+	}
+
+	code := post(testChannel, "s3cret",
+		`{"body": "build #12 passed\n\u001b[2Jall green", "kind": "notice"}`)
+	if code != http.StatusNoContent {
+		t.Fatalf("post failed %d", code)
+	}
+	if len(sesh.messages) != 1 {
+		t.Fatalf("message not broadcast %v", sesh.messages)
+	}
+	msg := sesh.messages[0]
+	if msg.From.Username() != "ci" || msg.Body != "build #12 passed\n[2Jall green" ||
+		msg.Kind != session.KIND_NOTICE || msg.ID == 0 {
+		t.Errorf("incorrect message %+v", msg)
+	}
+	if len(logger.logs) != 1 {
+		t.Errorf("message not logged")
+	}
+}
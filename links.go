@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+const (
+	// how much of a page we'll read looking for its title
+	MAX_TITLE_PAGE_SIZE = 64 * 1024
+	// longest title we'll keep, in characters
+	MAX_TITLE_LENGTH = 120
+)
+
+var (
+	ErrNoTitle = errors.New("page has no title")
+
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// looks up the titles of pages linked to in messages
+type TitleFetcher interface {
+	Title(url string) (string, error)
+}
+
+// fetches titles over http
+type HTTPTitleFetcher struct {
+	client *http.Client
+}
+
+// http title fetcher creation helper method, timeout covers the whole
+// request including reading the page
+func NewHTTPTitleFetcher(timeout time.Duration) *HTTPTitleFetcher {
+	return &HTTPTitleFetcher{client: &http.Client{Timeout: timeout}}
+}
+
+func (f *HTTPTitleFetcher) Title(url string) (string, error) {
+	resp, err := f.client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("unable to fetch " + url + ": " + resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !strings.Contains(contentType, "html") {
+		return "", ErrNoTitle
+	}
+
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_TITLE_PAGE_SIZE))
+	if err != nil {
+		return "", err
+	}
+	match := titlePattern.FindSubmatch(page)
+	if match == nil {
+		return "", ErrNoTitle
+	}
+	return cleanTitle(string(match[1])), nil
+}
+
+// tidies a title up for showing next to a link, pages can put anything they
+// like in there
+func cleanTitle(title string) string {
+	title = strings.Map(func(r rune) rune {
+		if r < 32 && r != '\t' && r != '\n' || r == 127 {
+			return -1
+		}
+		return r
+	}, html.UnescapeString(title))
+	title = strings.Join(strings.Fields(title), " ")
+
+	if runes := []rune(title); len(runes) > MAX_TITLE_LENGTH {
+		title = string(runes[:MAX_TITLE_LENGTH-3]) + "..."
+	}
+	return title
+}
+
+// looks up titles for a message's links in the background, everyone who can
+// see the message gets them as an update
+func (s *Server) previewLinks(msg session.Message) {
+	if s.titles == nil || len(msg.Links) == 0 {
+		return
+	}
+	go s.fetchTitles(msg)
+}
+
+func (s *Server) fetchTitles(msg session.Message) {
+	titles := make(map[string]string)
+	for _, link := range msg.Links {
+		title, err := s.titles.Title(link.URL)
+		if err == nil && title != "" {
+			titles[link.URL] = title
+		}
+	}
+	if len(titles) == 0 {
+		return
+	}
+
+	updated, err := s.history.Update(msg.ID, func(msg *session.Message) error {
+		if msg.Deleted {
+			return ErrNoSuchMessage
+		}
+		// the message may have been edited in the meantime, only links
+		// still there get titles. messages share links so they're copied
+		links := make([]session.Link, len(msg.Links))
+		for i, link := range msg.Links {
+			if title, ok := titles[link.URL]; ok {
+				link.Title = title
+			}
+			links[i] = link
+		}
+		msg.Links = links
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	s.broadcast(updated, UPDATE)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+func TestHTTPTitleFetcher(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html><head><TITLE>\n  Fish &amp; Chips\033[2J </TITLE>")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "<title>not really</title>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	fetcher := NewHTTPTitleFetcher(time.Second)
+	title, err := fetcher.Title(ts.URL + "/page")
+	if err != nil || title != "Fish & Chips[2J" {
+		t.Errorf("incorrect title %q %v", title, err)
+	}
+	if _, err := fetcher.Title(ts.URL + "/image"); err != ErrNoTitle {
+		t.Errorf("title taken from something that isn't a page %v", err)
+	}
+	if _, err := fetcher.Title(ts.URL + "/missing"); err == nil {
+		t.Errorf("title found for missing page")
+	}
+}
+
+func TestLinkPreviews(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		fmt.Fprint(w, "<title>Example</title>")
+	}))
+	defer ts.Close()
+
+	_, _, s := createMocks()
+	dan := createMockSession("dan")
+	jon := createMockSession("jon")
+	s.appendSession(dan)
+	s.appendSession(jon)
+
+	s.broadcast(session.NewMessage("have a look at "+ts.URL+"/a.", testChannel,
+		dan), MESSAGE)
+	msg := jon.messages[0]
+	if len(msg.Links) != 1 || msg.Links[0].URL != ts.URL+"/a" {
+		t.Fatalf("link not found %v", msg.Links)
+	}
+
+	// looked up in the background normally
+	s.SetTitleFetcher(NewHTTPTitleFetcher(time.Second))
+	s.fetchTitles(msg)
+	if len(jon.updates) != 1 || jon.updates[0].Links[0].Title != "Example" {
+		t.Errorf("title not sent to channel %v", jon.updates)
+	}
+	if msg.Links[0].Title != "" {
+		t.Errorf("links changed in place")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"log"
 	"os"
+	"time"
 
 	"github.com/spacemonkeygo/flagfile"
 )
@@ -12,12 +13,28 @@ var (
 	address     = flag.String("address", ":9876", "address for chat server to listen in on")
 	chatlogFile = flag.String("chatlog_file", "./chat.log",
 		"the file to log all messages to (created if does not already exist")
-	sessionBufferSize = flag.Int("session_buffer_size", 20,
-		"Limit of messages held in memory buffer for session")
+	sessionBufferSize = flag.Int("session_buffer_size", 500,
+		"Limit of messages held in memory buffer for session (and available for scrollback)")
 	minimumMessageLength = flag.Int("minimum_message_length", 1,
 		"The minimum characters required for a message")
 	defaultChannel = flag.String("default_channel", "general",
 		"the first channel a user enters when they join")
+	channelsFile = flag.String("channels_file", "./channels.json",
+		"the file channel topics, operators and modes are saved to")
+	accountsFile = flag.String("accounts_file", "./accounts.json",
+		"the file registered users and their ignore lists are saved to")
+	webhooksFile = flag.String("webhooks_file", "./webhooks.json",
+		"outgoing webhooks channel activity is posted to")
+	webhookQueueFile = flag.String("webhook_queue_file", "./webhook_queue.json",
+		"the file deliveries waiting to be posted to webhooks are saved to")
+	httpAddress = flag.String("http_address", "",
+		"address to serve incoming webhooks on, empty turns them off")
+	incomingWebhooksFile = flag.String("incoming_webhooks_file",
+		"./incoming_webhooks.json",
+		"channels other tools can post into and the tokens they need")
+	linkTitles = flag.Bool("link_titles", false,
+		"look up the titles of pages linked to in messages (the server "+
+			"fetches whatever users link to)")
 
 	USERNAME_COLORS = []string{
 		"red",
@@ -32,14 +49,33 @@ var (
 		"indigo",
 		"fuschia",
 		"aqua",
+		// terminals with 256 or more colors can tell these apart from the
+		// above, smaller terminals fall back to the closest of the 16
+		"pink",
+		"teal",
+		"gold",
+		"navy",
+		"coral",
+		"jade",
+		"violet",
+		"olive",
+		"sky",
+		"maroon",
+		"mint",
+		"plum",
+		"amber",
+		"slate",
+		"salmon",
+		"rose",
 	}
 )
 
 func main() {
 	flagfile.Load()
 
+	// the log holds private channels too, keep it to ourselves
 	chatLog, err := os.OpenFile(*chatlogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY,
-		0644)
+		0600)
 	if err != nil {
 		// this is critical to our service, panic if unable to open
 		log.Printf("Unable to open chat log file %v\n", err)
@@ -49,6 +85,55 @@ func main() {
 	server := NewServer(chatLog, *sessionBufferSize, USERNAME_COLORS,
 		*minimumMessageLength, *defaultChannel)
 
+	err = server.LoadChannels(*channelsFile)
+	if err != nil {
+		// starting without saved channels would quietly drop their
+		// settings, panic instead
+		log.Printf("Unable to load channels %v\n", err)
+		panic(err)
+	}
+
+	err = server.LoadAccounts(*accountsFile)
+	if err != nil {
+		// nobody registered would be able to log in, panic instead
+		log.Printf("Unable to load accounts %v\n", err)
+		panic(err)
+	}
+
+	err = server.LoadChatLog(*chatlogFile)
+	if err != nil {
+		// reusing message ids would be worse than not starting
+		log.Printf("Unable to read chat log %v\n", err)
+		panic(err)
+	}
+
+	err = server.LoadWebhooks(*webhooksFile, *webhookQueueFile)
+	if err != nil {
+		// a bad config would quietly stop activity going out, panic
+		// instead
+		log.Printf("Unable to load webhooks %v\n", err)
+		panic(err)
+	}
+
+	if *httpAddress != "" {
+		err = server.LoadIncomingWebhooks(*incomingWebhooksFile)
+		if err != nil {
+			log.Printf("Unable to load incoming webhooks %v\n", err)
+			panic(err)
+		}
+		go func() {
+			// tools relying on posting would quietly stop working,
+			// panic instead
+			err := server.ListenHTTP(*httpAddress)
+			log.Printf("Unable to serve http %v\n", err)
+			panic(err)
+		}()
+	}
+
+	if *linkTitles {
+		server.SetTitleFetcher(NewHTTPTitleFetcher(5 * time.Second))
+	}
+
 	err = server.Listen(*address)
 	if err != nil {
 		// we can't do anything if we can't listen to the address, panic to
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+var (
+	ErrNotInChannel      = errors.New("no such user in this channel")
+	ErrUnknownModeration = errors.New("unknown moderation action, actions are " +
+		"kick, ban, unban, mute, unmute, voice, devoice")
+
+	// every moderation action, each is a command of its own
+	moderationActions = []string{"kick", "ban", "unban", "mute", "unmute",
+		"voice", "devoice"}
+
+	// arguments for each moderation action, shown when they're missing
+	moderationUsage = map[string]string{
+		"kick":    "[user] [reason]",
+		"ban":     "[user|user@host mask] [duration] [reason]",
+		"unban":   "[user|user@host mask]",
+		"mute":    "[user] [duration]",
+		"unmute":  "[user]",
+		"voice":   "[user]",
+		"devoice": "[user]",
+	}
+
+	moderationDescriptions = map[string]string{
+		"kick": "removes someone from the channel",
+		"ban": "keeps matching users out of the channel, durations look " +
+			"like 10m or 2h and default to forever",
+		"unban":   "lifts a ban",
+		"mute":    "stops someone talking in the channel",
+		"unmute":  "lets someone talk again",
+		"voice":   "lets someone talk while the channel is moderated",
+		"devoice": "takes voice away",
+	}
+)
+
+// a ban from a channel. masks are either a plain username or a
+// user@host glob like *@10.0.0.*
+type Ban struct {
+	Mask   string    `json:"mask"`
+	By     string    `json:"by"`
+	Reason string    `json:"reason,omitempty"`
+	Set    time.Time `json:"set"`
+	// zero time never expires
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func (b Ban) Expired(now time.Time) bool {
+	return !b.Expires.IsZero() && now.After(b.Expires)
+}
+
+// whether a user@host mask (or plain username) matches a user connecting from
+// host
+func maskMatches(mask, username, host string) bool {
+	if !strings.Contains(mask, "@") {
+		matched, err := path.Match(mask, username)
+		return err == nil && matched
+	}
+	matched, err := path.Match(mask, username+"@"+host)
+	return err == nil && matched
+}
+
+// whether a user is banned from a channel
+func (c *Channel) IsBanned(username, host string, now time.Time) bool {
+	for _, ban := range c.Bans {
+		if !ban.Expired(now) && maskMatches(ban.Mask, username, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// whether a user is allowed to send messages to a channel
+func (c *Channel) CanSpeak(username string, now time.Time) bool {
+	if until, ok := c.Muted[username]; ok && (until.IsZero() || now.Before(until)) {
+		return false
+	}
+	return !c.Moderated || c.IsOperator(username) || c.Voiced[username]
+}
+
+func (r *ChannelRegistry) AddBan(name, username string, ban Ban) error {
+	return r.update(name, username, func(c *Channel) error {
+		// drop expired bans and any existing ban for the mask while we're
+		// here
+		now := time.Now()
+		bans := []Ban{ban}
+		for _, existing := range c.Bans {
+			if existing.Mask != ban.Mask && !existing.Expired(now) {
+				bans = append(bans, existing)
+			}
+		}
+		c.Bans = bans
+		return nil
+	})
+}
+
+func (r *ChannelRegistry) RemoveBan(name, username, mask string) error {
+	return r.update(name, username, func(c *Channel) error {
+		bans := c.Bans[:0]
+		for _, existing := range c.Bans {
+			if existing.Mask != mask {
+				bans = append(bans, existing)
+			}
+		}
+		if len(bans) == len(c.Bans) {
+			return errors.New("no ban for " + mask)
+		}
+		c.Bans = bans
+		return nil
+	})
+}
+
+// mutes target until the given time, zero time mutes until unmuted
+func (r *ChannelRegistry) Mute(name, username, target string, until time.Time) error {
+	return r.update(name, username, func(c *Channel) error {
+		if c.Muted == nil {
+			c.Muted = make(map[string]time.Time)
+		}
+		c.Muted[target] = until
+		return nil
+	})
+}
+
+func (r *ChannelRegistry) Unmute(name, username, target string) error {
+	return r.update(name, username, func(c *Channel) error {
+		delete(c.Muted, target)
+		return nil
+	})
+}
+
+func (r *ChannelRegistry) SetVoice(name, username, target string, voiced bool) error {
+	return r.update(name, username, func(c *Channel) error {
+		if c.Voiced == nil {
+			c.Voiced = make(map[string]bool)
+		}
+		if voiced {
+			c.Voiced[target] = true
+		} else {
+			delete(c.Voiced, target)
+		}
+		return nil
+	})
+}
+
+// pulls an optional duration off the front of args, zero means forever
+func parseDuration(args []string) (duration time.Duration, rest []string) {
+	if len(args) > 0 {
+		if d, err := time.ParseDuration(args[0]); err == nil && d > 0 {
+			return d, args[1:]
+		}
+	}
+	return 0, args
+}
+
+// turns a duration into when it runs out, zero stays zero (forever)
+func expiry(duration time.Duration) time.Time {
+	if duration == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(duration)
+}
+
+// describes how long something lasts for moderation events
+func describeDuration(duration time.Duration) string {
+	if duration == 0 {
+		return ""
+	}
+	return " for " + duration.String()
+}
+
+// finds a member of a channel by username
+func (s *Server) findMember(channel, username string) (session.Session, bool) {
+	s.sessionLock.Lock()
+	sesh, ok := s.sessions[username]
+	s.sessionLock.Unlock()
+	if !ok || !isMember(sesh, channel) {
+		return nil, false
+	}
+	return sesh, true
+}
+
+// removes a session from a channel and lets the channel know
+func (s *Server) kick(by session.Session, target session.Session, channel,
+	reason string) {
+	event := by.Username() + " kicked " + target.Username()
+	if reason != "" {
+		event += ": " + reason
+	}
+
+	// tell the channel first so the kicked user sees why
+	s.broadcast(systemEvent(event, channel, by), EVENT)
+
+	// an invitation would let them straight back in
+	s.channels.Uninvite(channel, by.Username(), target.Username())
+	err := target.Kicked(channel, event)
+	if err != nil {
+		s.removeSession(target)
+	}
+}
+
+// carries out moderation actions from channel operators, every action is
+// recorded as an event in the channel
+func (s *Server) Moderate(sesh session.Session, channel, action string,
+	args []string) error {
+	usage, ok := moderationUsage[action]
+	if !ok {
+		return ErrUnknownModeration
+	}
+	if len(args) == 0 || args[0] == "" {
+		return errors.New("usage: /" + action + " " + usage)
+	}
+
+	// check up front so non operators can't probe who's around
+	info, ok := s.channels.Get(channel)
+	if !ok {
+		return ErrNoSuchChannel
+	}
+	if !info.IsOperator(sesh.Username()) {
+		return ErrNotOperator
+	}
+
+	target, args := args[0], args[1:]
+	username := sesh.Username()
+	var event string
+	var err error
+
+	switch action {
+	case "kick":
+		member, ok := s.findMember(channel, target)
+		if !ok {
+			return ErrNotInChannel
+		}
+		s.kick(sesh, member, channel, strings.Join(args, " "))
+		return nil
+	case "ban":
+		duration, rest := parseDuration(args)
+		ban := Ban{Mask: target, By: username, Reason: strings.Join(rest, " "),
+			Set: time.Now(), Expires: expiry(duration)}
+		err = s.channels.AddBan(channel, username, ban)
+		if err != nil {
+			return err
+		}
+
+		event = username + " banned " + target + describeDuration(duration)
+		if ban.Reason != "" {
+			event += ": " + ban.Reason
+		}
+		s.broadcast(systemEvent(event, channel, sesh), EVENT)
+
+		// anyone in the channel matching the ban gets removed
+		for _, member := range s.ChannelMembers(channel) {
+			if maskMatches(target, member.Username(), member.RemoteAddr()) {
+				s.kick(sesh, member, channel, "banned")
+			}
+		}
+		return nil
+	case "unban":
+		err = s.channels.RemoveBan(channel, username, target)
+		event = username + " unbanned " + target
+	case "mute":
+		duration, _ := parseDuration(args)
+		err = s.channels.Mute(channel, username, target, expiry(duration))
+		event = username + " muted " + target + describeDuration(duration)
+	case "unmute":
+		err = s.channels.Unmute(channel, username, target)
+		event = username + " unmuted " + target
+	case "voice":
+		err = s.channels.SetVoice(channel, username, target, true)
+		event = username + " gave voice to " + target
+	case "devoice":
+		err = s.channels.SetVoice(channel, username, target, false)
+		event = username + " took voice from " + target
+	}
+
+	if err != nil {
+		return err
+	}
+	s.broadcast(systemEvent(event, channel, sesh), EVENT)
+	return nil
+}
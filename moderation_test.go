@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaskMatches(t *testing.T) {
+	if !maskMatches("dan", "dan", "10.0.0.1") {
+		t.Errorf("username mask didn't match")
+	}
+	if !maskMatches("*@10.0.0.*", "jon", "10.0.0.7") {
+		t.Errorf("host mask didn't match")
+	}
+	if maskMatches("*@10.0.0.*", "jon", "192.168.0.1") {
+		t.Errorf("host mask matched wrong host")
+	}
+	if maskMatches("da", "dan", "10.0.0.1") {
+		t.Errorf("partial username matched")
+	}
+}
+
+func TestChannelCanSpeak(t *testing.T) {
+	now := time.Now()
+	c := Channel{Operators: map[string]bool{"op": true},
+		Voiced: map[string]bool{"loud": true},
+		Muted: map[string]time.Time{"quiet": time.Time{},
+			"done": now.Add(-time.Minute)}}
+
+	if c.CanSpeak("quiet", now) {
+		t.Errorf("muted user can speak")
+	}
+	if !c.CanSpeak("done", now) {
+		t.Errorf("expired mute still applies")
+	}
+
+	c.Moderated = true
+	if c.CanSpeak("anyone", now) {
+		t.Errorf("unvoiced user can speak in moderated channel")
+	}
+	if !c.CanSpeak("op", now) || !c.CanSpeak("loud", now) {
+		t.Errorf("operators and voiced users should speak in moderated channel")
+	}
+}
+
+func TestModerateNeedsOperator(t *testing.T) {
+	_, sesh, s := createMocks()
+	op := createMockSession("op")
+	s.appendSession(sesh)
+	s.appendSession(op)
+	s.JoinChannel(op, "ops", "")
+	op.extraChannels = []string{"ops"}
+	sesh.extraChannels = []string{"ops"}
+
+	if err := s.Moderate(sesh, "ops", "kick", []string{"op"}); err != ErrNotOperator {
+		t.Errorf("non operator allowed to kick %v", err)
+	}
+
+	if err := s.Moderate(op, "ops", "kick", []string{sesh.Username()}); err != nil {
+		t.Fatalf("operator unable to kick %v", err)
+	}
+	if len(sesh.kicked) != 1 || isMember(sesh, "ops") {
+		t.Errorf("kicked session still in channel")
+	}
+}
+
+func TestBanBlocksJoin(t *testing.T) {
+	_, sesh, s := createMocks()
+	op := createMockSession("op")
+	s.JoinChannel(op, "ops", "")
+
+	err := s.Moderate(op, "ops", "ban", []string{"*@10.0.0.*", "1h", "spam"})
+	if err != nil {
+		t.Fatalf("unable to ban %v", err)
+	}
+	if _, err := s.JoinChannel(sesh, "ops", ""); err == nil {
+		t.Errorf("banned session allowed to join")
+	}
+
+	s.Moderate(op, "ops", "unban", []string{"*@10.0.0.*"})
+	if _, err := s.JoinChannel(sesh, "ops", ""); err != nil {
+		t.Errorf("unbanned session unable to join %v", err)
+	}
+}
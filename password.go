@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// how many rounds of hashing passwords go through, slows down guessing if a
+// store ever leaks
+const PASSWORD_HASH_ROUNDS = 10000
+
+// hashes password with a salt, returned as salt$hash
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	return hashWithSalt(hex.EncodeToString(salt), password), nil
+}
+
+func hashWithSalt(salt, password string) string {
+	sum := sha256.Sum256([]byte(salt + password))
+	for i := 1; i < PASSWORD_HASH_ROUNDS; i++ {
+		sum = sha256.Sum256(sum[:])
+	}
+	return salt + "$" + hex.EncodeToString(sum[:])
+}
+
+// checks a password against a hash produced by hashPassword
+func checkPassword(hashed, password string) bool {
+	pieces := strings.SplitN(hashed, "$", 2)
+	if len(pieces) != 2 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashed),
+		[]byte(hashWithSalt(pieces[0], password))) == 1
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+var (
+	ErrBadReaction = errors.New("reactions are short codes like +1, ack or eyes")
+
+	// keeps reactions to something every terminal can draw
+	reactionCode = regexp.MustCompile(`^[a-z0-9_+-]{1,16}$`)
+)
+
+// returns a copy of reactions with username added to or removed from code,
+// messages share reactions so they're never changed in place
+func setReaction(reactions map[string][]string, code, username string,
+	on bool) map[string][]string {
+	updated := make(map[string][]string, len(reactions)+1)
+	for existing, usernames := range reactions {
+		if existing != code {
+			updated[existing] = usernames
+		}
+	}
+
+	var usernames []string
+	for _, reactor := range reactions[code] {
+		if reactor != username {
+			usernames = append(usernames, reactor)
+		}
+	}
+	if on {
+		usernames = append(usernames, username)
+	}
+	if len(usernames) > 0 {
+		updated[code] = usernames
+	}
+
+	if len(updated) == 0 {
+		return nil
+	}
+	return updated
+}
+
+func hasReacted(reactions map[string][]string, code, username string) bool {
+	for _, reactor := range reactions[code] {
+		if reactor == username {
+			return true
+		}
+	}
+	return false
+}
+
+// adds a reaction to a message, or takes it away if the session already
+// reacted with the same code
+func (s *Server) React(sesh session.Session, id uint64, code string) error {
+	if id == 0 {
+		return ErrNoSuchMessage
+	}
+	code = strings.ToLower(strings.TrimSpace(code))
+	if !reactionCode.MatchString(code) {
+		return ErrBadReaction
+	}
+
+	change := LOG_REACT
+	msg, err := s.history.Update(id, func(msg *session.Message) error {
+		if msg.Deleted || !isMember(sesh, msg.Channel) {
+			return ErrNoSuchMessage
+		}
+
+		on := !hasReacted(msg.Reactions, code, sesh.Username())
+		if !on {
+			change = LOG_UNREACT
+		}
+		msg.Reactions = setReaction(msg.Reactions, code, sesh.Username(), on)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the log records who reacted, not who sent the message
+	record := msg
+	record.From, record.Body = sesh, code
+	s.logChange(record, change)
+	s.broadcast(msg, UPDATE)
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// when users were last seen, for /seen on people who aren't online
+type SeenTracker struct {
+	seen map[string]time.Time
+	mtx  sync.Mutex
+}
+
+// tracker creation helper method
+func NewSeenTracker() *SeenTracker {
+	return &SeenTracker{seen: make(map[string]time.Time)}
+}
+
+// records username being around at t, earlier times than we already know
+// about are ignored
+func (t *SeenTracker) Mark(username string, at time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if at.After(t.seen[username]) {
+		t.seen[username] = at
+	}
+}
+
+func (t *SeenTracker) Get(username string) time.Time {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.seen[username]
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLastSeenAndAway(t *testing.T) {
+	_, _, s := createMocks()
+	dan := createMockSession("dan")
+	jon := createMockSession("jon")
+	jon.away = "lunch"
+	s.appendSession(dan)
+	s.appendSession(jon)
+
+	s.DirectMessage(dan, "jon", "ping")
+	if len(dan.events) == 0 ||
+		!strings.Contains(dan.events[len(dan.events)-1].Body, "lunch") {
+		t.Errorf("no away reply sent %v", dan.events)
+	}
+
+	if _, online := s.LastSeen("jon"); !online {
+		t.Errorf("online user not reported online")
+	}
+	s.removeSession(jon)
+	if seen, online := s.LastSeen("jon"); online || seen.IsZero() {
+		t.Errorf("departed user not seen %v %v", seen, online)
+	}
+	if seen, _ := s.LastSeen("nobody"); !seen.IsZero() {
+		t.Errorf("unknown user seen %v", seen)
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
 	"io"
 	"log"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/taterbase/wally-chat/session"
 )
@@ -16,11 +20,18 @@ type BROADCAST_TYPE int
 const (
 	MESSAGE BROADCAST_TYPE = iota
 	EVENT
+	// a change to a message that's already been sent
+	UPDATE
 
 	// special ascii character specifically for separating records
 	RECORD_SEPARATOR = "\036"
 )
 
+var (
+	// ensure Server can host sessions
+	_ session.Host = (*Server)(nil)
+)
+
 type Server struct {
 	defaultChannel     string
 	sessions           map[string]session.Session
@@ -31,15 +42,101 @@ type Server struct {
 	usernameColors     []string
 	colorMtx           sync.Mutex
 	minimumMessageSize int
+	channels           *ChannelRegistry
+	history            *ChannelHistory
+	accounts           *AccountStore
+	seen               *SeenTracker
+	// looks up the titles of linked pages, nil leaves links without them
+	titles TitleFetcher
+	// commands handled by the server rather than sessions, see commands.go
+	commands *CommandRegistry
+	// posts channel activity to other tools, see webhooks.go
+	webhooks *WebhookDispatcher
+	// lets other tools post into channels, see incoming.go
+	incoming    []IncomingWebhook
+	incomingMtx sync.Mutex
+	// last message id handed out, only touched atomically
+	lastMessageID uint64
 }
 
 // server creation helper method
 func NewServer(chatlog io.Writer, sessionBufferSize int,
 	usernameColors []string, minimumMessageSize int, defaultChannel string) *Server {
-	return &Server{chatlog: chatlog, sessionBufferSize: sessionBufferSize,
+	s := &Server{chatlog: chatlog, sessionBufferSize: sessionBufferSize,
 		usernameColors: usernameColors, minimumMessageSize: minimumMessageSize,
 		defaultChannel: defaultChannel,
-		sessions:       make(map[string]session.Session)}
+		sessions:       make(map[string]session.Session),
+		channels:       NewChannelRegistry(),
+		history:        NewChannelHistory(sessionBufferSize),
+		accounts:       NewAccountStore(),
+		seen:           NewSeenTracker(),
+		commands:       NewCommandRegistry(),
+		webhooks:       NewWebhookDispatcher()}
+
+	for _, info := range session.TelnetCommands {
+		s.commands.Reserve(info)
+	}
+	for _, cmd := range builtinCommands() {
+		s.commands.Register(cmd)
+	}
+
+	// everyone starts out in the default channel so it always exists, it
+	// has no creator so it has no operators either
+	s.channels.Ensure(defaultChannel, "")
+	return s
+}
+
+// loads webhooks from hooksPath and starts delivering to them, deliveries
+// waiting to go out are kept at queuePath
+func (s *Server) LoadWebhooks(hooksPath, queuePath string) error {
+	err := s.webhooks.Load(hooksPath, queuePath)
+	if err != nil {
+		return err
+	}
+	go s.webhooks.Run()
+	return nil
+}
+
+// turns on looking up the titles of pages linked to in messages
+func (s *Server) SetTitleFetcher(titles TitleFetcher) {
+	s.titles = titles
+}
+
+// loads saved channels from path and keeps the registry saved there
+func (s *Server) LoadChannels(path string) error {
+	err := s.channels.Load(path)
+	if err != nil {
+		return err
+	}
+	_, _, err = s.channels.Ensure(s.defaultChannel, "")
+	return err
+}
+
+// loads registered users from path and keeps the store saved there
+func (s *Server) LoadAccounts(path string) error {
+	return s.accounts.Load(path)
+}
+
+// picks up channel history, when people were last seen and where message
+// ids left off from an existing chat log
+func (s *Server) LoadChatLog(path string) error {
+	var lastID uint64
+	err := readChatLog(path, func(record logRecord) {
+		s.history.replay(record)
+		s.seen.Mark(record.Username, record.T)
+		if record.ID > lastID {
+			lastID = record.ID
+		}
+	})
+	if lastID > atomic.LoadUint64(&s.lastMessageID) {
+		atomic.StoreUint64(&s.lastMessageID, lastID)
+	}
+	return err
+}
+
+// hands out the next message id
+func (s *Server) nextMessageID() uint64 {
+	return atomic.AddUint64(&s.lastMessageID, 1)
 }
 
 // kicks of server with appropriate address
@@ -63,6 +160,11 @@ func (s *Server) Listen(addr string) error {
 }
 
 func (s *Server) UsernameAvailable(username string) bool {
+	// so nobody can pass themselves off as an integration
+	if s.incomingName(username) {
+		return false
+	}
+
 	s.sessionLock.Lock()
 	defer s.sessionLock.Unlock()
 	if _, ok := s.sessions[username]; ok {
@@ -71,25 +173,318 @@ func (s *Server) UsernameAvailable(username string) bool {
 	return true
 }
 
+func (s *Server) Registered(username string) bool {
+	return s.accounts.Registered(username)
+}
+
+func (s *Server) Login(username, password string) bool {
+	return s.accounts.Authenticate(username, password)
+}
+
+// registers a session's username so nobody else can use it without the
+// password, whatever the session is already ignoring is saved with it
+func (s *Server) Register(sesh session.Session, password string) error {
+	err := s.accounts.Register(sesh.Username(), password)
+	if err != nil {
+		return err
+	}
+	return s.SaveIgnores(sesh)
+}
+
+func (s *Server) SavedIgnores(username string) []string {
+	return s.accounts.Ignores(username)
+}
+
+// saves a registered session's ignore list so it's there next time they log
+// in, unregistered sessions just keep theirs until they disconnect
+func (s *Server) SaveIgnores(sesh session.Session) error {
+	if !s.accounts.Registered(sesh.Username()) {
+		return nil
+	}
+
+	var ignores []string
+	for mask, ignored := range sesh.IgnoreList() {
+		if ignored {
+			ignores = append(ignores, mask)
+		}
+	}
+	return s.accounts.SetIgnores(sesh.Username(), ignores)
+}
+
+// whether sesh is ignoring from, ignore lists hold usernames and user@host
+// masks
+func ignoring(sesh, from session.Session) bool {
+	if sesh == from {
+		return false
+	}
+	for mask, ignored := range sesh.IgnoreList() {
+		if ignored && maskMatches(mask, from.Username(), from.RemoteAddr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// sends a message straight to one user. direct messages aren't written to
+// the chat log and are quietly dropped if the recipient is ignoring the
+// sender
+func (s *Server) DirectMessage(sesh session.Session, username,
+	body string) error {
+	if len(strings.TrimSpace(body)) < s.minimumMessageSize {
+		return errors.New("message too short")
+	}
+
+	s.sessionLock.Lock()
+	target, ok := s.sessions[username]
+	s.sessionLock.Unlock()
+	if !ok {
+		return errors.New(username + " isn't online")
+	}
+	if ignoring(target, sesh) {
+		return nil
+	}
+	s.seen.Mark(sesh.Username(), time.Now())
+
+	// show up in whatever they're looking at
+	msg := session.NewMessage(body, target.Channel(), sesh)
+	msg.To, msg.Kind = username, session.KIND_DM
+	msg.ID = s.nextMessageID()
+	msg.Links = session.FindLinks(body)
+	err := target.SendMessage(msg)
+	if err != nil {
+		s.removeSession(target)
+		return errors.New(username + " isn't online")
+	}
+
+	// let the sender know not to expect an answer any time soon
+	if away := target.Away(); away != "" {
+		sesh.SendEvent(systemEvent(username+" is away: "+away,
+			sesh.Channel(), target))
+	}
+	return nil
+}
+
+// online sessions are as fresh as their last input, everyone else is
+// whenever they last said something or disconnected
+func (s *Server) LastSeen(username string) (seen time.Time, online bool) {
+	s.sessionLock.Lock()
+	sesh, ok := s.sessions[username]
+	s.sessionLock.Unlock()
+	if ok {
+		return sesh.LastActive(), true
+	}
+	return s.seen.Get(username), false
+}
+
+// whether a session is a member of a channel
+func isMember(sesh session.Session, channel string) bool {
+	for _, member := range sesh.Channels() {
+		if member == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// lists everyone currently in a channel, sorted by username
+func (s *Server) ChannelMembers(channel string) (members []session.Session) {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+	for _, sesh := range s.sessions {
+		if isMember(sesh, channel) {
+			members = append(members, sesh)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Username() < members[j].Username()
+	})
+	return members
+}
+
+// public view of a channel for sessions
+func (s *Server) channelInfo(channel Channel) session.ChannelInfo {
+	return session.ChannelInfo{Name: channel.Name, Topic: channel.Topic,
+		Modes: channel.Modes(), Members: len(s.ChannelMembers(channel.Name))}
+}
+
+// registers a session's move into a channel, creating the channel if it
+// doesn't exist yet. private channels need a password or an invitation, and
+// only those let in get the channel's history
+func (s *Server) JoinChannel(sesh session.Session, name,
+	password string) (info session.ChannelInfo, err error) {
+	name, err = normalizeChannelName(name)
+	if err != nil {
+		return info, err
+	}
+
+	channel, created, err := s.channels.Ensure(name, sesh.Username())
+	if err != nil {
+		// the channel still exists in memory, we just couldn't save it
+		log.Printf("Unable to save channel %s %v\n", name, err)
+	}
+
+	if channel.IsBanned(sesh.Username(), sesh.RemoteAddr(), time.Now()) {
+		return info, errors.New("you are banned from #" + name)
+	}
+	if created {
+		log.Printf("%s created channel #%s\n", sesh.Username(), name)
+
+		// creating a channel with a password makes it the channel's
+		if password != "" {
+			err = s.channels.SetMode(name, sesh.Username(), "+k", password)
+			if err != nil {
+				return info, err
+			}
+			channel, _ = s.channels.Get(name)
+		}
+	} else if err = channel.CanJoin(sesh.Username(), password); err != nil {
+		return info, err
+	}
+
+	// let everyone already there know, the session itself isn't a member
+	// until we've said yes
+	if !isMember(sesh, name) {
+		s.broadcast(systemEvent(sesh.Username()+" has joined #"+name,
+			name, sesh), EVENT)
+	}
+
+	info = s.channelInfo(channel)
+	info.History = s.history.Recent(name)
+	return info, nil
+}
+
+// lets a channel know a session has left it
+func (s *Server) LeaveChannel(sesh session.Session, channel string) {
+	s.broadcast(systemEvent(sesh.Username()+" has left #"+channel,
+		channel, sesh), EVENT)
+}
+
+// invites username into a channel, the inviter has to be in it themselves
+func (s *Server) Invite(sesh session.Session, username, channel string) error {
+	channel, err := normalizeChannelName(channel)
+	if err != nil {
+		return err
+	}
+	if !isMember(sesh, channel) {
+		return errors.New("you need to be in #" + channel + " to invite people to it")
+	}
+
+	err = s.channels.Invite(channel, sesh.Username(), username)
+	if err != nil {
+		return err
+	}
+
+	s.broadcast(systemEvent(sesh.Username()+" invited "+username+
+		" to #"+channel, channel, sesh), EVENT)
+
+	// let them know if they're around, otherwise the invitation waits
+	s.sessionLock.Lock()
+	target, ok := s.sessions[username]
+	s.sessionLock.Unlock()
+	if ok && !isMember(target, channel) {
+		target.SendEvent(systemEvent(sesh.Username()+" invited you to #"+
+			channel+", /join #"+channel+" to accept", target.Channel(), sesh))
+	}
+	return nil
+}
+
+func (s *Server) ChannelInfo(name string) session.ChannelInfo {
+	channel, ok := s.channels.Get(name)
+	if !ok {
+		return session.ChannelInfo{Name: name}
+	}
+	return s.channelInfo(channel)
+}
+
+// lists channels a session is allowed to know about, secret and private
+// channels are only shown to those in them
+func (s *Server) ListChannels(sesh session.Session) (infos []session.ChannelInfo) {
+	for _, channel := range s.channels.List() {
+		if (channel.Secret || channel.Private()) && !isMember(sesh, channel.Name) {
+			continue
+		}
+		infos = append(infos, s.channelInfo(channel))
+	}
+	return infos
+}
+
+func (s *Server) SetTopic(sesh session.Session, channel, topic string) error {
+	err := s.channels.SetTopic(channel, sesh.Username(), topic)
+	if err != nil {
+		return err
+	}
+
+	s.broadcast(systemEvent(sesh.Username()+" changed the topic to: "+
+		topic, channel, sesh), EVENT)
+	return nil
+}
+
+func (s *Server) SetChannelMode(sesh session.Session, channel, mode,
+	arg string) error {
+	err := s.channels.SetMode(channel, sesh.Username(), mode, arg)
+	if err != nil {
+		return err
+	}
+
+	// never announce channel passwords
+	change := mode
+	if arg != "" && mode != "+k" {
+		change += " " + arg
+	}
+	s.broadcast(systemEvent(sesh.Username()+" set mode "+change,
+		channel, sesh), EVENT)
+	return nil
+}
+
 // function responsible for logging all messages
 func (s *Server) logMessage(msg session.Message) (err error) {
+	return s.writeLogRecord(msg, string(msg.Kind))
+}
+
+// logs an edit or deletion of an earlier message, the record keeps the
+// original message's id so the log has every revision
+func (s *Server) logChange(msg session.Message, change string) (err error) {
+	msg.T = time.Now()
+	return s.writeLogRecord(msg, change)
+}
+
+func (s *Server) writeLogRecord(msg session.Message, kind string) (err error) {
 	// avoid chat log writing races
 	s.chatlogMtx.Lock()
 	defer s.chatlogMtx.Unlock()
 
+	// every record gets a line of its own whatever the body ended with
 	_, err = s.chatlog.Write([]byte(strconv.FormatInt(msg.T.UnixNano(), 10) +
 		RECORD_SEPARATOR + msg.Channel + RECORD_SEPARATOR +
-		msg.From.Username() + RECORD_SEPARATOR + msg.Body))
+		msg.From.Username() + RECORD_SEPARATOR + kind + RECORD_SEPARATOR +
+		strconv.FormatUint(msg.ID, 10) + RECORD_SEPARATOR +
+		strconv.FormatUint(msg.ReplyTo, 10) + RECORD_SEPARATOR +
+		encodeLogBody(msg.Body) + "\r\n"))
 	return err
 }
 
+// builds an event the server sends about something from did, like joining
+// or changing the topic
+func systemEvent(body, channel string, from session.Session) session.Message {
+	event := session.NewMessage(body, channel, from)
+	event.Kind = session.KIND_SYSTEM
+	return event
+}
+
 // function responsible for adding new sessions to the server
 func (s *Server) appendSession(sesh session.Session) {
 	s.sessionLock.Lock()
 	s.sessions[sesh.Username()] = sesh
 	s.sessionLock.Unlock()
-	s.broadcast(session.NewMessage(sesh.Username()+" is now online",
+	s.announceSession(sesh)
+}
+
+// lets everyone know a new session is around and hands it anything left for
+// it with /tell
+func (s *Server) announceSession(sesh session.Session) {
+	s.broadcast(systemEvent(sesh.Username()+" is now online",
 		sesh.Channel(), sesh), EVENT)
+	s.deliverTells(sesh)
 }
 
 // Ensures connection is closed and then removed from list of sessions
@@ -98,9 +493,12 @@ func (s *Server) removeSession(sesh session.Session) {
 	sesh.Close()
 	delete(s.sessions, sesh.Username())
 	s.sessionLock.Unlock()
+	s.seen.Mark(sesh.Username(), time.Now())
 
-	s.broadcast(session.NewMessage(sesh.Username()+" has disconnected",
-		sesh.Channel(), sesh), EVENT)
+	for _, channel := range sesh.Channels() {
+		s.broadcast(systemEvent(sesh.Username()+" has disconnected",
+			channel, sesh), EVENT)
+	}
 }
 
 // assigns mostly unique (rotating set) color to session
@@ -110,11 +508,52 @@ func (s *Server) getUsernameColor() (color string) {
 	// session
 	s.colorMtx.Lock()
 	defer s.colorMtx.Unlock()
+	if len(s.usernameColors) == 0 {
+		return ""
+	}
 	color = s.usernameColors[0]
 	s.usernameColors = append(s.usernameColors[1:], color)
 	return color
 }
 
+// creates a bot starting in the default channel, ready for AddBot once its
+// callbacks are set
+func (s *Server) NewBot(name string) *session.Bot {
+	return session.NewBot(name, s.getUsernameColor(), s.defaultChannel)
+}
+
+// adds a bot to the server as though it had connected, bots start in the
+// default channel and join others like everyone else
+func (s *Server) AddBot(bot *session.Bot) error {
+	name := bot.Username()
+	if strings.TrimSpace(name) == "" {
+		return errors.New("bots need a name")
+	}
+	channels := bot.Channels()
+	if len(channels) != 1 || channels[0] != s.defaultChannel {
+		return errors.New("bots have to start in #" + s.defaultChannel)
+	}
+
+	if s.incomingName(name) {
+		return errors.New(name + " is used by an incoming webhook")
+	}
+
+	// checked and claimed together so two bots can't both get the name
+	s.sessionLock.Lock()
+	_, taken := s.sessions[name]
+	if taken || s.Registered(name) {
+		s.sessionLock.Unlock()
+		return errors.New(name + " is already taken")
+	}
+	s.sessions[name] = bot
+	s.sessionLock.Unlock()
+
+	msgChan, eventChan, doneChan := bot.GetMessages(s)
+	s.announceSession(bot)
+	go s.relay(bot, msgChan, eventChan, doneChan)
+	return nil
+}
+
 // handles the logic of an open connection
 // meant to be spun out in a goroutine
 func (s *Server) handleConnection(conn net.Conn) {
@@ -122,8 +561,14 @@ func (s *Server) handleConnection(conn net.Conn) {
 	sesh := session.NewTelnet(conn, s.sessionBufferSize, s.getUsernameColor(),
 		s.defaultChannel)
 
-	msgChan, eventChan, doneChan := sesh.GetMessages(s.UsernameAvailable)
+	msgChan, eventChan, doneChan := sesh.GetMessages(s)
 	s.appendSession(sesh)
+	s.relay(sesh, msgChan, eventChan, doneChan)
+}
+
+// passes everything a session sends on to the other sessions until it's done
+func (s *Server) relay(sesh session.Session, msgChan, eventChan chan session.Message,
+	doneChan chan error) {
 	var msg, event session.Message
 	for {
 		select {
@@ -136,40 +581,72 @@ func (s *Server) handleConnection(conn net.Conn) {
 		case <-doneChan:
 			// session has told us it's done, remove it
 			s.removeSession(sesh)
-			break
+			return
 		}
 	}
 }
 
 // handles logic for sending messages to appropriate sessions
 func (s *Server) broadcast(msg session.Message, bt BROADCAST_TYPE) {
-	if len(strings.TrimSpace(msg.Body)) < s.minimumMessageSize {
+	// deleted messages have no body left, that's fine
+	if bt != UPDATE && len(strings.TrimSpace(msg.Body)) < s.minimumMessageSize {
 		return
 	}
 
+	// muted users and those without voice in moderated channels don't get
+	// to send messages, let them know why nothing happened
+	if bt == MESSAGE {
+		channel, ok := s.channels.Get(msg.Channel)
+		if ok && !channel.CanSpeak(msg.From.Username(), time.Now()) {
+			msg.From.SendEvent(systemEvent("you can't speak in #"+
+				msg.Channel+" right now", msg.Channel, msg.From))
+			return
+		}
+	}
+
 	// if it's a message log it, otherwise don't record
 	if bt == MESSAGE {
+		msg.ID = s.nextMessageID()
+
+		// replies only make sense to earlier messages we still have in the
+		// same channel, anything else could make a thread loop back on
+		// itself
+		if msg.ReplyTo != 0 {
+			parent, ok := s.history.Find(msg.ReplyTo)
+			if !ok || parent.Channel != msg.Channel || msg.ReplyTo >= msg.ID {
+				msg.ReplyTo = 0
+			}
+		}
+
+		msg.Links = session.FindLinks(msg.Body)
 		s.logMessage(msg)
+		s.history.Record(msg)
+		s.seen.Mark(msg.From.Username(), msg.T)
 	}
 
-	// we batch failed sessions for removal later. sessions are locked
-	// during broadcast so we can't remove until the lock is released
+	// we batch failed sessions for removal later
 	var failedSessions []session.Session
 	var err error
 
+	// work from a snapshot of sessions so the lock isn't held while sending,
+	// sessions are free to call back into the server while drawing
 	s.sessionLock.Lock()
+	recipients := make([]session.Session, 0, len(s.sessions))
 	for _, sesh := range s.sessions {
-		// if a message's channel is different from a session's don't show it
-		if sesh.Channel() != msg.Channel {
+		recipients = append(recipients, sesh)
+	}
+	s.sessionLock.Unlock()
+
+	for _, sesh := range recipients {
+		// only members of a message's channel get to see it
+		if !isMember(sesh, msg.Channel) {
 			continue
 		}
 
-		// respect ignore list and don't broadcast from ignored sessions
-		// TODO: simply username based, potentially include ip at later date
-		if isIgnored, ok := sesh.IgnoreList()[msg.From.Username()]; ok {
-			if isIgnored {
-				continue
-			}
+		// respect ignore list and don't broadcast messages or events from
+		// ignored sessions
+		if ignoring(sesh, msg.From) {
+			continue
 		}
 
 		// broadcast message based on type appropriately so sessions
@@ -179,16 +656,28 @@ func (s *Server) broadcast(msg session.Message, bt BROADCAST_TYPE) {
 			err = sesh.SendMessage(msg)
 		case EVENT:
 			err = sesh.SendEvent(msg)
+		case UPDATE:
+			err = sesh.UpdateMessage(msg)
 		}
 
 		if err != nil {
 			failedSessions = append(failedSessions, sesh)
 		}
 	}
-	s.sessionLock.Unlock()
 
-	// now that the session lock has been released remove bad sessions
+	// now that everyone has been sent to, remove bad sessions
 	for _, sesh := range failedSessions {
 		s.removeSession(sesh)
 	}
+
+	if bt != UPDATE {
+		// only webhooks naming private and secret channels get their
+		// activity
+		channel, ok := s.channels.Get(msg.Channel)
+		hidden := ok && (channel.Private() || channel.Secret)
+		s.webhooks.Notify(msg, bt == EVENT, hidden)
+	}
+	if bt == MESSAGE {
+		s.previewLinks(msg)
+	}
 }
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/taterbase/wally-chat/session"
 )
@@ -29,12 +32,24 @@ type mockSession struct {
 	username   string
 	ignoreList map[string]bool
 	messages   []session.Message
+
+	// channels the session is in other than testChannel
+	extraChannels []string
+	// channels the session has been kicked from
+	kicked  []string
+	away    string
+	events  []session.Message
+	updates []session.Message
 }
 
 func (ms *mockSession) Channel() string {
 	return testChannel
 }
 
+func (ms *mockSession) Channels() []string {
+	return append([]string{testChannel}, ms.extraChannels...)
+}
+
 func (ms *mockSession) IgnoreList() map[string]bool {
 	return ms.ignoreList
 }
@@ -47,7 +62,19 @@ func (ms *mockSession) UsernameColor() string {
 	return "fuschia"
 }
 
-func (ms *mockSession) GetMessages(func(string) bool) (msg, event chan session.Message, done chan error) {
+func (ms *mockSession) RemoteAddr() string {
+	return "10.0.0.1"
+}
+
+func (ms *mockSession) Away() string {
+	return ms.away
+}
+
+func (ms *mockSession) LastActive() time.Time {
+	return time.Now()
+}
+
+func (ms *mockSession) GetMessages(session.Host) (msg, event chan session.Message, done chan error) {
 	msg = make(chan session.Message)
 	event = make(chan session.Message)
 	done = make(chan error)
@@ -62,7 +89,25 @@ func (ms *mockSession) SendMessage(msg session.Message) error {
 	return nil
 }
 
-func (ms *mockSession) SendEvent(session.Message) error {
+func (ms *mockSession) SendEvent(event session.Message) error {
+	ms.events = append(ms.events, event)
+	return nil
+}
+
+func (ms *mockSession) UpdateMessage(msg session.Message) error {
+	ms.updates = append(ms.updates, msg)
+	return nil
+}
+
+func (ms *mockSession) Kicked(channel, reason string) error {
+	ms.kicked = append(ms.kicked, channel)
+	remaining := ms.extraChannels[:0]
+	for _, extra := range ms.extraChannels {
+		if extra != channel {
+			remaining = append(remaining, extra)
+		}
+	}
+	ms.extraChannels = remaining
 	return nil
 }
 
@@ -81,7 +126,7 @@ func createMockSession(username string) *mockSession {
 func createMocks() (*mockLogger, *mockSession, *Server) {
 	logger := &mockLogger{}
 	sesh := createMockSession("testuser")
-	s := NewServer(logger, 0, []string{}, 1, testChannel)
+	s := NewServer(logger, 10, []string{}, 1, testChannel)
 	return logger, sesh, s
 }
 
@@ -150,8 +195,8 @@ func TestShouldSeparateRecordsAppropriately(t *testing.T) {
 
 	row := string(logger.logs[0])
 	pieces := strings.Split(row, RECORD_SEPARATOR)
-	if len(pieces) != 4 {
-		t.Errorf("incorrect number of records in chat row %d", len(pieces))
+	if len(pieces) != 7 {
+		t.Fatalf("incorrect number of records in chat row %d", len(pieces))
 	}
 
 	if pieces[0] != strconv.FormatInt(msg.T.UnixNano(), 10) {
@@ -166,7 +211,194 @@ func TestShouldSeparateRecordsAppropriately(t *testing.T) {
 		t.Errorf("third record is not username %s", pieces[2])
 	}
 
-	if pieces[3] != msg.Body {
-		t.Errorf("fourth record is not body %s", pieces[3])
+	if pieces[3] != string(msg.Kind) {
+		t.Errorf("fourth record is not kind %s", pieces[3])
+	}
+
+	if pieces[4] != "1" {
+		t.Errorf("fifth record is not message id %s", pieces[4])
+	}
+
+	if pieces[5] != "0" {
+		t.Errorf("sixth record is not reply %s", pieces[5])
+	}
+
+	if pieces[6] != msg.Body+"\r\n" {
+		t.Errorf("seventh record is not body %s", pieces[6])
+	}
+}
+
+func TestChannelMembersAreSorted(t *testing.T) {
+	_, _, s := createMocks()
+	s.appendSession(createMockSession("zed"))
+	s.appendSession(createMockSession("amy"))
+
+	members := s.ChannelMembers(testChannel)
+	if len(members) != 2 || members[0].Username() != "amy" {
+		t.Errorf("incorrect channel members %v", members)
+	}
+
+	if len(s.ChannelMembers("elsewhere")) != 0 {
+		t.Errorf("members listed for wrong channel")
+	}
+}
+
+func TestSecretChannelsAreHidden(t *testing.T) {
+	_, sesh, s := createMocks()
+	other := createMockSession("jon")
+	s.JoinChannel(other, "hideout", "")
+	s.SetChannelMode(other, "hideout", "+s", "")
+
+	for _, info := range s.ListChannels(sesh) {
+		if info.Name == "hideout" {
+			t.Errorf("secret channel listed for non member")
+		}
+	}
+}
+
+func TestBroadcastRoutesByMembership(t *testing.T) {
+	_, _, s := createMocks()
+	sesh1 := createMockSession("dan")
+	sesh2 := createMockSession("jon")
+	sesh1.extraChannels = []string{"ops"}
+	s.appendSession(sesh1)
+	s.appendSession(sesh2)
+
+	s.broadcast(session.NewMessage("deploying", "ops", sesh1), MESSAGE)
+	if len(sesh1.messages) != 1 {
+		t.Errorf("member of other channel didn't get message")
+	}
+	if len(sesh2.messages) != 0 {
+		t.Errorf("non member got message for channel")
+	}
+
+	if len(s.ChannelMembers("ops")) != 1 || len(s.ChannelMembers(testChannel)) != 2 {
+		t.Errorf("incorrect channel membership")
+	}
+}
+
+func TestPrivateChannels(t *testing.T) {
+	_, sesh, s := createMocks()
+	op := createMockSession("op")
+	op.extraChannels = []string{"ops"}
+	s.JoinChannel(op, "ops", "hunter2")
+	s.broadcast(session.NewMessage("top secret", "ops", op), MESSAGE)
+
+	for _, info := range s.ListChannels(sesh) {
+		if info.Name == "ops" {
+			t.Errorf("private channel listed for non member")
+		}
+	}
+
+	info, err := s.JoinChannel(sesh, "ops", "wrong")
+	if err != ErrBadPassword || len(info.History) != 0 {
+		t.Errorf("joined with wrong password %v %v", err, info.History)
+	}
+
+	info, err = s.JoinChannel(sesh, "ops", "hunter2")
+	if err != nil || len(info.History) != 1 {
+		t.Errorf("unable to join with password %v %v", err, info.History)
+	}
+
+	s.SetChannelMode(op, "ops", "+i", "")
+	if _, err = s.JoinChannel(sesh, "ops", "hunter2"); err != ErrInviteOnly {
+		t.Errorf("joined invite only channel without invite %v", err)
+	}
+
+	if err = s.Invite(sesh, sesh.Username(), "ops"); err == nil {
+		t.Errorf("non member able to invite")
+	}
+	if err = s.Invite(op, sesh.Username(), "ops"); err != nil {
+		t.Fatalf("unable to invite %v", err)
+	}
+	if _, err = s.JoinChannel(sesh, "ops", ""); err != nil {
+		t.Errorf("invited user unable to join %v", err)
+	}
+}
+
+func TestJoinEventsComeFromTheServer(t *testing.T) {
+	_, sesh, s := createMocks()
+	op := createMockSession("op")
+	op.extraChannels = []string{"ops"}
+	s.appendSession(op)
+
+	if _, err := s.JoinChannel(sesh, "ops", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(op.events) == 0 {
+		t.Fatalf("join not announced")
+	}
+	event := op.events[len(op.events)-1]
+	if event.Body != "testuser has joined #ops" ||
+		event.Kind != session.KIND_SYSTEM {
+		t.Errorf("incorrect join event %+v", event)
+	}
+}
+
+func TestBots(t *testing.T) {
+	logger, sesh, s := createMocks()
+	s.appendSession(sesh)
+
+	replies := make(chan string, 10)
+	bot := s.NewBot("factoids")
+	bot.OnMessage = func(bot *session.Bot, msg session.Message) {
+		if msg.From.Username() == bot.Username() {
+			replies <- msg.Body
+			return
+		}
+		if msg.Body == "!wally" {
+			bot.Send("wally is a chat server")
+		}
+	}
+	if err := s.AddBot(bot); err != nil {
+		t.Fatal(err)
+	}
+	if s.UsernameAvailable("factoids") {
+		t.Errorf("bot wasn't added as a session")
+	}
+	if err := s.AddBot(s.NewBot("factoids")); err == nil {
+		t.Errorf("bot added with a name that's taken")
+	}
+	// only one of several bots after the same name gets it
+	var wg sync.WaitGroup
+	var added int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.AddBot(s.NewBot("standup")) == nil {
+				atomic.AddInt32(&added, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if added != 1 {
+		t.Errorf("%d bots added with the same name", added)
+	}
+
+	elsewhere := session.NewBot("elsewhere", "", "other")
+	if err := s.AddBot(elsewhere); err == nil {
+		t.Errorf("bot added outside the default channel")
+	}
+
+	s.broadcast(session.NewMessage("!wally", testChannel, sesh), MESSAGE)
+	select {
+	case reply := <-replies:
+		if reply != "wally is a chat server" {
+			t.Errorf("incorrect reply %q", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("bot never replied")
+	}
+	if len(logger.logs) != 2 {
+		t.Errorf("reply wasn't logged %d", len(logger.logs))
+	}
+
+	bot.Close()
+	for i := 0; i < 100 && !s.UsernameAvailable("factoids"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !s.UsernameAvailable("factoids") {
+		t.Errorf("closed bot wasn't removed")
 	}
 }
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"sort"
+	"strings"
+)
+
+// asks for the password of a registered username, loading their saved
+// settings if it's right
+func (s *Telnet) login(host Host, username string) (ok bool, err error) {
+	// clients that speak telnet stop echoing while we have ECHO, so the
+	// password doesn't end up on screen
+	hideEcho := s.richClient || s.termType != ""
+	if hideEcho {
+		s.raw([]byte{IAC, WILL, ECHO})
+		defer s.raw([]byte{IAC, WONT, ECHO})
+	}
+	s.raw([]byte("password: "))
+
+	b := make([]byte, EXPECTED_MSG_SIZE)
+	for {
+		n, err := s.conn.Read(b)
+		if err != nil {
+			return false, err
+		}
+
+		input, err := s.handleTelnetCommands(b[:n])
+		if err != nil {
+			return false, err
+		}
+
+		password := strings.TrimSpace(string(input))
+		if password == "" {
+			continue
+		}
+		if hideEcho {
+			// the user's enter key wasn't echoed either
+			s.raw([]byte("\r\n"))
+		}
+		if !host.Login(username, password) {
+			return false, nil
+		}
+
+		ignores := host.SavedIgnores(username)
+		s.bufferMtx.Lock()
+		for _, mask := range ignores {
+			s.ignoreList[mask] = true
+		}
+		s.bufferMtx.Unlock()
+		return true, nil
+	}
+}
+
+// handles /ignore [user|user@host], ignoring again stops ignoring
+func (s *Telnet) ignoreCommand(mask string) (err error) {
+	if mask == "" {
+		// if inappropriate args sent for ignore inform user of proper
+		// usage
+		return s.SendEvent(s.newMessage([]byte(ignoreHelp)))
+	}
+
+	// allow user to stop ignoring a user by doing /ignore [user] again, a
+	// mask that isn't in the list yet reads as false
+	s.bufferMtx.Lock()
+	s.ignoreList[mask] = !s.ignoreList[mask]
+	s.bufferMtx.Unlock()
+
+	err = s.host.SaveIgnores(s)
+	if err != nil {
+		return s.SendEvent(s.newMessage([]byte("unable to save ignore list: " +
+			err.Error())))
+	}
+	return s.displayIgnoreStatus(mask)
+}
+
+// handles /ignores, listing everyone being ignored
+func (s *Telnet) ignoresCommand() (err error) {
+	var ignores []string
+	for mask, ignored := range s.IgnoreList() {
+		if ignored {
+			ignores = append(ignores, mask)
+		}
+	}
+	if len(ignores) == 0 {
+		return s.SendEvent(s.newMessage([]byte("not ignoring anyone")))
+	}
+
+	sort.Strings(ignores)
+	return s.SendEvent(s.newMessage([]byte("ignoring: " +
+		strings.Join(ignores, ", "))))
+}
@@ -0,0 +1,306 @@
+package session
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBotClosed       = errors.New("bot has been closed")
+	ErrNotInBotChannel = errors.New("bot isn't in that channel")
+
+	// ensure Bot adheres to the Session interface
+	_ Session = (*Bot)(nil)
+)
+
+// a session driven by Go code rather than a connection, for automated users
+// like reminders or factoid bots. the server treats it like anyone else
+type Bot struct {
+	Name  string `json:"username"`
+	Chan  string `json:"channel"`
+	color string
+
+	// called with every message and event the bot sees, its own messages
+	// included. callbacks run one at a time on a goroutine of their own so
+	// they're free to Send. set them before adding the bot, without one
+	// those messages are dropped
+	OnMessage func(bot *Bot, msg Message)   `json:"-"`
+	OnEvent   func(bot *Bot, event Message) `json:"-"`
+
+	host       Host
+	channels   []string
+	home       string
+	lastActive time.Time
+	mtx        sync.Mutex
+
+	msg   chan Message
+	event chan Message
+	done  chan error
+
+	// messages and events waiting for the callbacks
+	inbox  []botDelivery
+	wake   chan struct{}
+	stop   chan struct{}
+	closed bool
+}
+
+// something waiting to be handed to a bot's callbacks
+type botDelivery struct {
+	msg   Message
+	event bool
+}
+
+// helper method to create a new bot, channel is the one it starts in
+func NewBot(name, usernameColor, channel string) *Bot {
+	return &Bot{Name: name, Chan: channel, color: usernameColor,
+		channels: []string{channel}, home: channel, lastActive: time.Now(),
+		wake: make(chan struct{}, 1), stop: make(chan struct{})}
+}
+
+func (b *Bot) Channel() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.Chan
+}
+
+func (b *Bot) Channels() []string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return append([]string(nil), b.channels...)
+}
+
+// bots don't ignore anyone
+func (b *Bot) IgnoreList() map[string]bool {
+	return map[string]bool{}
+}
+
+func (b *Bot) Username() string {
+	return b.Name
+}
+
+func (b *Bot) UsernameColor() string {
+	return b.color
+}
+
+// bots aren't connecting from anywhere
+func (b *Bot) RemoteAddr() string {
+	return ""
+}
+
+func (b *Bot) Away() string {
+	return ""
+}
+
+func (b *Bot) LastActive() time.Time {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.lastActive
+}
+
+func (b *Bot) GetMessages(host Host) (msg, event chan Message,
+	done chan error) {
+	b.host = host
+	b.msg = make(chan Message)
+	b.event = make(chan Message)
+	b.done = make(chan error, 1)
+	go b.dispatch()
+	return b.msg, b.event, b.done
+}
+
+// queues something for the callbacks, the server never waits on a bot
+func (b *Bot) deliver(delivery botDelivery) error {
+	if (delivery.event && b.OnEvent == nil) ||
+		(!delivery.event && b.OnMessage == nil) {
+		return nil
+	}
+
+	b.mtx.Lock()
+	if b.closed {
+		b.mtx.Unlock()
+		return ErrBotClosed
+	}
+	b.inbox = append(b.inbox, delivery)
+	b.mtx.Unlock()
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// hands queued messages and events to the callbacks until the bot is closed
+func (b *Bot) dispatch() {
+	for {
+		select {
+		case <-b.wake:
+		case <-b.stop:
+			return
+		}
+
+		for {
+			b.mtx.Lock()
+			if len(b.inbox) == 0 || b.closed {
+				b.mtx.Unlock()
+				break
+			}
+			delivery := b.inbox[0]
+			b.inbox = b.inbox[1:]
+			b.mtx.Unlock()
+
+			if delivery.event {
+				b.OnEvent(b, delivery.msg)
+			} else {
+				b.OnMessage(b, delivery.msg)
+			}
+		}
+	}
+}
+
+func (b *Bot) SendMessage(msg Message) error {
+	return b.deliver(botDelivery{msg: msg})
+}
+
+func (b *Bot) SendEvent(event Message) error {
+	return b.deliver(botDelivery{msg: event, event: true})
+}
+
+// bots see messages as they were sent, changes aren't passed on
+func (b *Bot) UpdateMessage(Message) error {
+	return nil
+}
+
+// whether the bot is a member of a channel
+func (b *Bot) isMember(channel string) bool {
+	for _, member := range b.Channels() {
+		if member == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// sends a message to the channel the bot is in, like someone typing it
+func (b *Bot) Send(body string) error {
+	return b.SendTo(b.Channel(), body)
+}
+
+// sends a message to one of the bot's channels
+func (b *Bot) SendTo(channel, body string) error {
+	if !b.isMember(channel) {
+		return ErrNotInBotChannel
+	}
+	if b.msg == nil {
+		return errors.New("bot hasn't been added to a server")
+	}
+
+	b.mtx.Lock()
+	b.lastActive = time.Now()
+	b.mtx.Unlock()
+
+	select {
+	case b.msg <- NewMessage(body, channel, b):
+		return nil
+	case <-b.stop:
+		return ErrBotClosed
+	}
+}
+
+// joins a channel and makes it the one Send goes to, errors come from the
+// host (bans, invite only channels)
+func (b *Bot) Join(channel, password string) error {
+	if b.host == nil {
+		return errors.New("bot hasn't been added to a server")
+	}
+	info, err := b.host.JoinChannel(b, channel, password)
+	if err != nil {
+		return err
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	found := false
+	for _, member := range b.channels {
+		found = found || member == info.Name
+	}
+	if !found {
+		b.channels = append(b.channels, info.Name)
+	}
+	b.Chan = info.Name
+	return nil
+}
+
+// removes the bot from a channel, leaving its last channel isn't allowed
+func (b *Bot) Leave(channel string) error {
+	channel = strings.TrimPrefix(channel, "#")
+	if !b.isMember(channel) {
+		return ErrNotInBotChannel
+	}
+	if !b.removeChannel(channel) {
+		return errors.New("can't leave the bot's only channel, Close it instead")
+	}
+	b.host.LeaveChannel(b, channel)
+	return nil
+}
+
+// takes channel out of the bot's channels unless it's the only one left
+func (b *Bot) removeChannel(channel string) (removed bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if len(b.channels) == 1 {
+		return false
+	}
+
+	remaining := make([]string, 0, len(b.channels)-1)
+	for _, member := range b.channels {
+		if member != channel {
+			remaining = append(remaining, member)
+		}
+	}
+	b.channels = remaining
+	if b.Chan == channel {
+		b.Chan = remaining[0]
+	}
+	return true
+}
+
+// like telnet sessions, bots kicked from everything go back home or are
+// closed if they can't
+func (b *Bot) Kicked(channel, reason string) error {
+	if !b.isMember(channel) {
+		return nil
+	}
+	if !b.removeChannel(channel) {
+		if channel == b.home {
+			return b.Close()
+		}
+		_, err := b.host.JoinChannel(b, b.home, "")
+		if err != nil {
+			return b.Close()
+		}
+		b.mtx.Lock()
+		b.channels, b.Chan = []string{b.home}, b.home
+		b.mtx.Unlock()
+	}
+
+	return b.SendEvent(NewMessage("removed from #"+channel+" ("+reason+
+		"), now in #"+b.Channel(), channel, b))
+}
+
+// stops the bot, the server removes it once it's noticed
+func (b *Bot) Close() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.stop)
+
+	if b.done != nil {
+		b.done <- nil
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBotCallbacks(t *testing.T) {
+	seen := make(chan Message, 10)
+	bot := NewBot("standup", "", "general")
+	bot.OnMessage = func(bot *Bot, msg Message) {
+		// sending from a callback mustn't hold anything up
+		bot.Send("standup in 5 minutes")
+		seen <- msg
+	}
+	bot.OnEvent = func(bot *Bot, event Message) {
+		seen <- event
+	}
+	msgChan, _, done := bot.GetMessages(&mockHost{})
+
+	bot.SendMessage(NewMessage("morning", "general", bot))
+	select {
+	case msg := <-msgChan:
+		if msg.Body != "standup in 5 minutes" || msg.Channel != "general" ||
+			msg.From != Session(bot) {
+			t.Errorf("incorrect message %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("bot never sent its reply")
+	}
+	if msg := <-seen; msg.Body != "morning" {
+		t.Errorf("callback got %q", msg.Body)
+	}
+
+	bot.SendEvent(NewMessage("dan is now online", "general", bot))
+	if event := <-seen; event.Body != "dan is now online" {
+		t.Errorf("event callback got %q", event.Body)
+	}
+
+	bot.Close()
+	bot.Close()
+	select {
+	case <-done:
+	default:
+		t.Errorf("closing didn't tell the host")
+	}
+	if err := bot.Send("anyone there?"); err != ErrBotClosed {
+		t.Errorf("closed bot sent a message %v", err)
+	}
+}
+
+func TestBotChannels(t *testing.T) {
+	bot := NewBot("standup", "", "general")
+	bot.GetMessages(&mockHost{})
+	defer bot.Close()
+
+	if err := bot.SendTo("ops", "hi"); err != ErrNotInBotChannel {
+		t.Errorf("sent to a channel the bot isn't in %v", err)
+	}
+	if err := bot.Join("forbidden", ""); err == nil {
+		t.Errorf("host refusal ignored")
+	}
+	if err := bot.Join("#ops", ""); err != nil {
+		t.Fatal(err)
+	}
+	if bot.Channel() != "ops" || len(bot.Channels()) != 2 {
+		t.Errorf("incorrect channels %s %v", bot.Channel(), bot.Channels())
+	}
+
+	bot.Kicked("ops", "bob kicked standup")
+	if bot.Channel() != "general" || len(bot.Channels()) != 1 {
+		t.Errorf("still in ops %v", bot.Channels())
+	}
+	if err := bot.Leave("general"); err == nil {
+		t.Errorf("left the only channel")
+	}
+}
@@ -0,0 +1,228 @@
+package session
+
+import (
+	"strconv"
+	"strings"
+)
+
+var (
+	leaveHelp  = "usage: /leave [channel]"
+	switchHelp = "usage: /switch [channel|number] (or Alt-number)"
+)
+
+// describes a channel in a single line for /list and /who
+func DescribeChannel(info ChannelInfo) string {
+	description := "#" + info.Name + " (" + strconv.Itoa(info.Members) + ")"
+	if info.Modes != "" {
+		description += " " + info.Modes
+	}
+	if info.Topic != "" {
+		description += ": " + info.Topic
+	}
+	return description
+}
+
+// whether the session is a member of a channel
+func (s *Telnet) isMember(channel string) bool {
+	s.bufferMtx.Lock()
+	defer s.bufferMtx.Unlock()
+	for _, member := range s.channels {
+		if member == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// makes a channel the one being viewed and composed in
+func (s *Telnet) switchTo(channel string) {
+	s.bufferMtx.Lock()
+	defer s.bufferMtx.Unlock()
+	s.Chan = channel
+	delete(s.unread, channel)
+	// scrolling is relative to what we were looking at
+	s.scroll = 0
+}
+
+// adds the session to a channel once the host has agreed to it, channels we're
+// already in are just switched to
+func (s *Telnet) joinCommand(channel, password string) (err error) {
+	if s.isMember(strings.TrimPrefix(channel, "#")) {
+		return s.switchCommand(channel)
+	}
+
+	info, err := s.host.JoinChannel(s, channel, password)
+	if err != nil {
+		return s.SendEvent(s.newMessage([]byte("unable to join #" +
+			strings.TrimPrefix(channel, "#") + ": " + err.Error())))
+	}
+
+	if !s.isMember(info.Name) {
+		s.bufferMtx.Lock()
+		s.channels = append(s.channels, info.Name)
+		s.bufferMtx.Unlock()
+	}
+
+	// update session's channel for messages
+	s.switchTo(info.Name)
+
+	// catch up on what was said before we got here
+	for _, msg := range info.History {
+		entry := bufferEntry{msg: msg}
+		s.appendToBuffer(entry)
+		if !s.richClient {
+			err = s.printLine(entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	announcement := "now in channel #" + s.Channel()
+	if info.Topic != "" {
+		announcement += ", topic: " + info.Topic
+	}
+	return s.SendEvent(s.newMessage([]byte(announcement)))
+}
+
+// handles /leave [channel], defaulting to the current channel
+func (s *Telnet) leaveCommand(channel string) (err error) {
+	channel = strings.TrimPrefix(channel, "#")
+	if channel == "" {
+		channel = s.Channel()
+	}
+
+	if !s.isMember(channel) {
+		return s.SendEvent(s.newMessage([]byte("not in #" + channel + ", " +
+			leaveHelp)))
+	}
+
+	s.bufferMtx.Lock()
+	if len(s.channels) == 1 {
+		s.bufferMtx.Unlock()
+		return s.SendEvent(s.newMessage([]byte("can't leave your only channel, " +
+			"use /part to disconnect")))
+	}
+
+	remaining := make([]string, 0, len(s.channels)-1)
+	for _, member := range s.channels {
+		if member != channel {
+			remaining = append(remaining, member)
+		}
+	}
+	s.channels = remaining
+	delete(s.buffers, channel)
+	delete(s.unread, channel)
+	next := remaining[0]
+	s.bufferMtx.Unlock()
+
+	if s.Channel() == channel {
+		s.switchTo(next)
+	}
+
+	s.host.LeaveChannel(s, channel)
+	return s.SendEvent(s.newMessage([]byte("left #" + channel + ", now in #" +
+		s.Channel())))
+}
+
+// handles /switch [channel|number]
+func (s *Telnet) switchCommand(target string) (err error) {
+	target = strings.TrimPrefix(strings.TrimSpace(target), "#")
+
+	// numbers are positions in the channel list, as shown in the status bar
+	if n, err := strconv.Atoi(target); err == nil {
+		s.bufferMtx.Lock()
+		if n >= 1 && n <= len(s.channels) {
+			target = s.channels[n-1]
+		}
+		s.bufferMtx.Unlock()
+	}
+
+	if target == "" || !s.isMember(target) {
+		return s.SendEvent(s.newMessage([]byte(switchHelp)))
+	}
+
+	s.switchTo(target)
+	return nil
+}
+
+// switches channels if the input is an Alt-number keypress, which terminals
+// send as escape followed by the digit
+func (s *Telnet) handleSwitchKeys(b []byte) (isSwitch bool, err error) {
+	input := strings.TrimSpace(string(b))
+	if len(input) != 2 || input[0] != '\033' || input[1] < '1' ||
+		input[1] > '9' {
+		return false, nil
+	}
+
+	err = s.switchCommand(input[1:])
+	if err != nil {
+		return true, err
+	}
+	return true, s.redrawAll()
+}
+
+// numbered list of channels for the status bar with unread counts, the
+// current channel is bracketed. expects bufferMtx to be held
+func (s *Telnet) channelTabs() string {
+	tabs := make([]string, 0, len(s.channels))
+	for i, channel := range s.channels {
+		tab := strconv.Itoa(i+1) + ":#" + channel
+		if count := s.unread[channel]; count > 0 {
+			tab += "(" + strconv.Itoa(count) + ")"
+		}
+		if channel == s.Chan {
+			tab = "[" + tab + "]"
+		}
+		tabs = append(tabs, tab)
+	}
+	return strings.Join(tabs, " ")
+}
+
+// called by the host when someone else removes the session from a channel.
+// sessions kicked out of everything go back to where they started, or get
+// disconnected if that's where they were kicked from
+func (s *Telnet) Kicked(channel, reason string) error {
+	if !s.isMember(channel) {
+		return nil
+	}
+
+	s.bufferMtx.Lock()
+	remaining := make([]string, 0, len(s.channels))
+	for _, member := range s.channels {
+		if member != channel {
+			remaining = append(remaining, member)
+		}
+	}
+	s.channels = remaining
+	delete(s.buffers, channel)
+	delete(s.unread, channel)
+	s.bufferMtx.Unlock()
+
+	if len(remaining) == 0 {
+		if channel == s.home {
+			return s.Close()
+		}
+
+		// the host still gets a say, we may be banned from home too
+		_, err := s.host.JoinChannel(s, s.home, "")
+		if err != nil {
+			return s.Close()
+		}
+		s.bufferMtx.Lock()
+		s.channels = []string{s.home}
+		s.bufferMtx.Unlock()
+		remaining = []string{s.home}
+	}
+
+	if s.Channel() == channel {
+		s.switchTo(remaining[0])
+	}
+
+	err := s.SendEvent(s.newMessage([]byte("removed from #" + channel + " (" +
+		reason + "), now in #" + s.Channel())))
+	if err != nil {
+		return err
+	}
+	return s.redrawAll()
+}
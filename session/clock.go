@@ -0,0 +1,108 @@
+package session
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// formats users can pick between with /timefmt
+	CLOCK_24H = "15:04:05"
+	CLOCK_12H = "3:04:05PM"
+
+	// format for the separator drawn when the day changes in the buffer
+	DATE_SEPARATOR_FORMAT = "Monday, January 2 2006"
+)
+
+var (
+	tzHelp      = "usage: /tz [zone] (e.g. America/New_York, UTC)"
+	timefmtHelp = "usage: /timefmt [12h|24h]"
+)
+
+// timestamp as the session's user wants to see it, expects bufferMtx to be
+// held since rendering for broadcasts happens on other goroutines
+func (s *Telnet) formatTime(t time.Time) string {
+	return t.In(s.location).Format(s.clockFormat)
+}
+
+// formatTime for callers that don't hold bufferMtx
+func (s *Telnet) timestamp(t time.Time) string {
+	s.bufferMtx.Lock()
+	defer s.bufferMtx.Unlock()
+	return s.formatTime(t)
+}
+
+// the calendar day a time falls on for the session's user, expects bufferMtx
+// to be held
+func (s *Telnet) day(t time.Time) string {
+	return t.In(s.location).Format("2006-01-02")
+}
+
+// separator line for the day a time falls on, expects bufferMtx to be held
+func (s *Telnet) dateSeparator(t time.Time) string {
+	return s.eventColor() + "-- " + t.In(s.location).Format(DATE_SEPARATOR_FORMAT) +
+		" --" + s.messageColor()
+}
+
+// switches the session to a timezone by name, returning false if we don't
+// know it
+func (s *Telnet) setTimezone(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	// the TZ variable some clients send may have a leading colon
+	location, err := time.LoadLocation(strings.TrimPrefix(name, ":"))
+	if err != nil {
+		return false
+	}
+	s.bufferMtx.Lock()
+	s.location = location
+	s.bufferMtx.Unlock()
+	return true
+}
+
+// handles /tz [zone], with no arguments the current zone is shown
+func (s *Telnet) tzCommand(args []string) (err error) {
+	zone := ""
+	if len(args) > 0 {
+		zone = strings.TrimSpace(args[0])
+	}
+
+	if zone == "" {
+		s.bufferMtx.Lock()
+		current := s.location.String()
+		s.bufferMtx.Unlock()
+		return s.SendEvent(s.newMessage([]byte("timezone is " + current +
+			", " + tzHelp)))
+	}
+
+	if !s.setTimezone(zone) {
+		return s.SendEvent(s.newMessage([]byte("unknown timezone " + zone +
+			", " + tzHelp)))
+	}
+	return s.SendEvent(s.newMessage([]byte("timezone is now " +
+		strings.TrimPrefix(zone, ":"))))
+}
+
+// handles /timefmt [12h|24h]
+func (s *Telnet) timefmtCommand(args []string) (err error) {
+	format := ""
+	if len(args) > 0 {
+		format = strings.TrimSpace(args[0])
+	}
+
+	clockFormat := ""
+	switch format {
+	case "12h":
+		clockFormat = CLOCK_12H
+	case "24h":
+		clockFormat = CLOCK_24H
+	default:
+		return s.SendEvent(s.newMessage([]byte(timefmtHelp)))
+	}
+	s.bufferMtx.Lock()
+	s.clockFormat = clockFormat
+	s.bufferMtx.Unlock()
+	return s.SendEvent(s.newMessage([]byte("timestamps are now " + format)))
+}
@@ -0,0 +1,234 @@
+package session
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// how many colors a terminal can display
+type ColorDepth int
+
+const (
+	MONOCHROME ColorDepth = iota
+	ANSI_16
+	ANSI_256
+	TRUECOLOR
+)
+
+var (
+	// names users can give /theme to override the detected color depth
+	COLOR_DEPTHS = map[string]ColorDepth{
+		"none":      MONOCHROME,
+		"16":        ANSI_16,
+		"256":       ANSI_256,
+		"truecolor": TRUECOLOR,
+	}
+
+	// every named color we know how to draw. names are what the server hands
+	// out so the palette can grow without servers and sessions disagreeing,
+	// terminals with fewer colors fall back to the closest of the 16
+	PALETTE = map[string]PaletteColor{
+		"red":     {"0;31", 160, [3]uint8{205, 49, 49}},
+		"orange":  {"1;31", 208, [3]uint8{255, 135, 0}},
+		"green":   {"0;32", 34, [3]uint8{0, 175, 0}},
+		"lime":    {"1;32", 118, [3]uint8{135, 255, 0}},
+		"brown":   {"0;33", 130, [3]uint8{175, 95, 0}},
+		"yellow":  {"1;33", 226, [3]uint8{255, 255, 0}},
+		"blue":    {"0;34", 27, [3]uint8{0, 95, 255}},
+		"indigo":  {"1;34", 63, [3]uint8{95, 95, 255}},
+		"purple":  {"0;35", 91, [3]uint8{135, 0, 175}},
+		"fuschia": {"1;35", 201, [3]uint8{255, 0, 255}},
+		"cyan":    {"0;36", 37, [3]uint8{0, 175, 175}},
+		"aqua":    {"1;36", 51, [3]uint8{0, 255, 255}},
+		"pink":    {"1;35", 218, [3]uint8{255, 175, 215}},
+		"teal":    {"0;36", 30, [3]uint8{0, 135, 135}},
+		"navy":    {"0;34", 18, [3]uint8{0, 0, 135}},
+		"olive":   {"0;33", 100, [3]uint8{135, 135, 0}},
+		"maroon":  {"0;31", 88, [3]uint8{135, 0, 0}},
+		"gold":    {"1;33", 220, [3]uint8{255, 215, 0}},
+		"coral":   {"1;31", 209, [3]uint8{255, 135, 95}},
+		"violet":  {"1;35", 177, [3]uint8{215, 135, 255}},
+		"mint":    {"1;32", 121, [3]uint8{135, 255, 175}},
+		"salmon":  {"1;31", 210, [3]uint8{255, 135, 135}},
+		"sky":     {"1;36", 117, [3]uint8{135, 215, 255}},
+		"plum":    {"0;35", 96, [3]uint8{135, 95, 135}},
+		"rose":    {"0;31", 204, [3]uint8{255, 95, 135}},
+		"jade":    {"0;32", 35, [3]uint8{0, 175, 95}},
+		"amber":   {"0;33", 214, [3]uint8{255, 175, 0}},
+		"slate":   {"1;34", 67, [3]uint8{95, 135, 175}},
+	}
+
+	// in the event we get a color we don't recognize, just use fuschia
+	DEFAULT_USERNAME_COLOR = "fuschia"
+
+	// themes users can pick between with /theme
+	THEMES = map[string]Theme{
+		// bold white text with dim events, meant for dark backgrounds
+		"default": {
+			Message: PaletteColor{"1;37", 255, [3]uint8{238, 238, 238}},
+			Event:   PaletteColor{"1;30", 244, [3]uint8{128, 128, 128}},
+		},
+		// dark text for light backgrounds, swapping out colors that
+		// disappear against white
+		"light": {
+			Message: PaletteColor{"0;30", 232, [3]uint8{8, 8, 8}},
+			Event:   PaletteColor{"1;30", 242, [3]uint8{108, 108, 108}},
+			Usernames: map[string]PaletteColor{
+				"yellow": {"0;33", 136, [3]uint8{175, 135, 0}},
+				"lime":   {"0;32", 70, [3]uint8{95, 175, 0}},
+				"aqua":   {"0;36", 31, [3]uint8{0, 135, 175}},
+				"gold":   {"0;33", 136, [3]uint8{175, 135, 0}},
+				"mint":   {"0;32", 35, [3]uint8{0, 175, 95}},
+				"sky":    {"0;34", 32, [3]uint8{0, 135, 215}},
+			},
+		},
+		// brighter events for terminals where dark gray is unreadable
+		"contrast": {
+			Message: PaletteColor{"1;37", 231, [3]uint8{255, 255, 255}},
+			Event:   PaletteColor{"0;37", 250, [3]uint8{188, 188, 188}},
+		},
+		// no color at all, only cursor movement
+		"mono": {Mono: true},
+	}
+)
+
+// a color as it should be drawn at each color depth
+type PaletteColor struct {
+	// SGR parameters for 16 color terminals
+	Ansi16 string
+	// index into the xterm 256 color palette
+	Ansi256 int
+	RGB     [3]uint8
+}
+
+// escape sequence for drawing the color at a given depth
+func (c PaletteColor) Sequence(depth ColorDepth) string {
+	switch depth {
+	case ANSI_16:
+		return "\033[" + c.Ansi16 + "m"
+	case ANSI_256:
+		return "\033[0;38;5;" + strconv.Itoa(c.Ansi256) + "m"
+	case TRUECOLOR:
+		return "\033[0;38;2;" + strconv.Itoa(int(c.RGB[0])) + ";" +
+			strconv.Itoa(int(c.RGB[1])) + ";" + strconv.Itoa(int(c.RGB[2])) + "m"
+	}
+	return ""
+}
+
+type Theme struct {
+	Message PaletteColor
+	Event   PaletteColor
+	// overrides for username colors that don't work with the theme
+	Usernames map[string]PaletteColor
+	// draw without any color regardless of what the terminal can do
+	Mono bool
+}
+
+// works out how many colors a client can display from its terminal type and
+// environment
+func detectColorDepth(termType string, environ map[string]string) ColorDepth {
+	colorTerm := strings.ToLower(environ["COLORTERM"])
+	termType = strings.ToLower(termType)
+
+	switch {
+	case termType != "" && isDumbTerminal(termType) && colorTerm == "":
+		return MONOCHROME
+	case colorTerm == "truecolor" || colorTerm == "24bit" ||
+		strings.Contains(termType, "truecolor") ||
+		strings.Contains(termType, "direct"):
+		return TRUECOLOR
+	case strings.Contains(termType, "256color"):
+		return ANSI_256
+	}
+	return ANSI_16
+}
+
+// the depth we actually draw at, taking the theme into account
+func (s *Telnet) drawDepth() ColorDepth {
+	if THEMES[s.theme].Mono {
+		return MONOCHROME
+	}
+	return s.depth
+}
+
+// escape sequence for regular message text
+func (s *Telnet) messageColor() string {
+	return THEMES[s.theme].Message.Sequence(s.drawDepth())
+}
+
+// escape sequence for events and other secondary text
+func (s *Telnet) eventColor() string {
+	return THEMES[s.theme].Event.Sequence(s.drawDepth())
+}
+
+// translates plain text color to an escape sequence
+func (s *Telnet) usernameColor(color string) string {
+	if themed, ok := THEMES[s.theme].Usernames[color]; ok {
+		return themed.Sequence(s.drawDepth())
+	}
+	if paletteColor, ok := PALETTE[color]; ok {
+		return paletteColor.Sequence(s.drawDepth())
+	}
+	return PALETTE[DEFAULT_USERNAME_COLOR].Sequence(s.drawDepth())
+}
+
+// name of a color depth as it would be given to /theme
+func depthName(depth ColorDepth) string {
+	for name, named := range COLOR_DEPTHS {
+		if named == depth {
+			return name
+		}
+	}
+	return ""
+}
+
+// sorted list of keys for showing choices to users
+func themeChoices() string {
+	var names []string
+	for name := range THEMES {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var depths []string
+	for name := range COLOR_DEPTHS {
+		depths = append(depths, name)
+	}
+	sort.Strings(depths)
+
+	return strings.Join(names, ", ") + " (or colors: " +
+		strings.Join(depths, ", ") + ")"
+}
+
+// handles /theme [name|colors], with no arguments the current settings are
+// shown
+func (s *Telnet) themeCommand(args []string) (err error) {
+	choice := ""
+	if len(args) > 0 {
+		choice = strings.ToLower(strings.TrimSpace(args[0]))
+	}
+
+	// rendering for broadcasts reads these from other goroutines
+	s.bufferMtx.Lock()
+	theme, depth := s.theme, s.depth
+	if choice == "" {
+		s.bufferMtx.Unlock()
+		return s.SendEvent(s.newMessage([]byte("theme is " + theme + " at " +
+			depthName(depth) + " colors, available: " + themeChoices())))
+	}
+
+	if named, ok := COLOR_DEPTHS[choice]; ok {
+		s.depth, depth = named, named
+	} else if _, ok := THEMES[choice]; ok {
+		s.theme, theme = choice, choice
+	} else {
+		s.bufferMtx.Unlock()
+		return s.SendEvent(s.newMessage([]byte("usage: /theme [name], available: " +
+			themeChoices())))
+	}
+	s.bufferMtx.Unlock()
+
+	return s.SendEvent(s.newMessage([]byte("theme is now " + theme + " at " +
+		depthName(depth) + " colors")))
+}
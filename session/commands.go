@@ -0,0 +1,93 @@
+package session
+
+import "strings"
+
+var (
+	// commands telnet sessions handle themselves because they only change
+	// the session, everything else is handed to the host (see
+	// Host.RunCommand). hosts reserve these names so nothing registered
+	// there is hidden behind them
+	TelnetCommands = []CommandInfo{
+		{"help", "[command]", "lists commands, or explains one"},
+		{"join", "[channel] [password]", "joins a channel, creating it if " +
+			"it doesn't exist"},
+		{"leave", "[channel]", "leaves a channel, defaults to the current one"},
+		{"switch", "[channel|number]", "switches channel, Alt-number works too"},
+		{"part", "", "disconnects"},
+		{"ignore", "[user|user@host]", "ignores someone, or stops ignoring them"},
+		{"ignores", "", "lists who you're ignoring"},
+		{"away", "[reason]", "marks you as away"},
+		{"back", "", "marks you as back"},
+		{"seen", "[user]", "says when someone was last around"},
+		{"highlight", "[word]", "highlights a word like your username"},
+		{"mentions", "", "lists mentions from other channels"},
+		{"links", "", "lists recent links in the channel"},
+		{"me", "[action]", "sends an action"},
+		{"paste", "", "starts a multi-line message, end it with a lone " +
+			PASTE_END},
+		{"edit", "[message]", "changes your last message, s/old/new/ works too"},
+		{"delete", "", "deletes your last message"},
+		{"reply", "[id] [message]", "replies to a message"},
+		{"thread", "[id]", "shows a message and its replies"},
+		{"scroll", "[up|down|end]", "scrolls the chat, PageUp/PageDown work too"},
+		{"layout", "[sidebar|status]", "toggles the member list and status bar"},
+		{"theme", "[name|colors]", "changes colors"},
+		{"tz", "[zone]", "sets the timezone times are shown in"},
+		{"timefmt", "[12h|24h]", "sets the clock format"},
+	}
+)
+
+// how a command is typed, like "/join [channel] [password]"
+func describeUsage(cmd CommandInfo) string {
+	if cmd.Usage == "" {
+		return "/" + cmd.Name
+	}
+	return "/" + cmd.Name + " " + cmd.Usage
+}
+
+// every command this session understands, the host lists TelnetCommands
+// along with its own
+func (s *Telnet) commands() []CommandInfo {
+	if s.host == nil {
+		return TelnetCommands
+	}
+	return s.host.Commands()
+}
+
+// handles /help [command]
+func (s *Telnet) helpCommand(name string) (err error) {
+	commands := s.commands()
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		usages := make([]string, len(commands))
+		for i, cmd := range commands {
+			usages[i] = describeUsage(cmd)
+		}
+		return s.SendEvent(s.newMessage([]byte("available commands: " +
+			strings.Join(usages, ", ") + ", /help [command] for more")))
+	}
+
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return s.SendEvent(s.newMessage([]byte(describeUsage(cmd) +
+				": " + cmd.Help)))
+		}
+	}
+	return s.SendEvent(s.newMessage([]byte("no such command /" + name)))
+}
+
+// hands a command to the host, isCommand is false if the host doesn't know
+// it either
+func (s *Telnet) hostCommand(cmd []string) (isCommand bool, err error) {
+	handled, cmdErr := s.host.RunCommand(s, strings.TrimSpace(cmd[0]), cmd[1:])
+	if !handled {
+		return false, nil
+	}
+	if cmdErr != nil {
+		err = s.SendEvent(s.newMessage([]byte(cmdErr.Error())))
+		if err != nil {
+			return true, err
+		}
+	}
+	return true, s.redrawAll()
+}
@@ -0,0 +1,94 @@
+package session
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	editHelp = "usage: /edit [new message], changes your last message " +
+		"(s/old/new/ works too)"
+
+	// s/old/new/ style substitutions on the last message sent
+	substitution = regexp.MustCompile(`^s/([^/]+)/([^/]*)/?$`)
+)
+
+// swaps out an earlier message in the buffer for a newer version of it
+func (s *Telnet) UpdateMessage(msg Message) (err error) {
+	s.bufferMtx.Lock()
+	found := false
+	buffer := s.buffers[msg.Channel]
+	for i := range buffer {
+		if !buffer[i].event && buffer[i].msg.ID == msg.ID {
+			buffer[i].msg = msg
+			found = true
+		}
+	}
+	if s.lastSent.ID == msg.ID {
+		s.lastSent = msg
+	}
+	s.bufferMtx.Unlock()
+
+	if !found {
+		return nil
+	}
+	if !s.richClient {
+		// lines already printed can't be changed, print the new version
+		return s.printLine(bufferEntry{msg: msg})
+	}
+	return s.redrawChat()
+}
+
+// the last message this session sent that's still around to be changed
+func (s *Telnet) lastMessage() (msg Message, ok bool) {
+	s.bufferMtx.Lock()
+	defer s.bufferMtx.Unlock()
+	return s.lastSent, s.lastSent.ID != 0 && !s.lastSent.Deleted
+}
+
+// handles /edit [new message]
+func (s *Telnet) editCommand(body string) (err error) {
+	last, ok := s.lastMessage()
+	if body == "" || !ok {
+		return s.SendEvent(s.newMessage([]byte(editHelp)))
+	}
+
+	err = s.host.EditMessage(s, last.ID, body)
+	if err != nil {
+		return s.SendEvent(s.newMessage([]byte("unable to edit: " +
+			err.Error())))
+	}
+	return nil
+}
+
+// handles /delete, removing the last message sent
+func (s *Telnet) deleteCommand() (err error) {
+	last, ok := s.lastMessage()
+	if !ok {
+		return s.SendEvent(s.newMessage([]byte("nothing to delete")))
+	}
+
+	err = s.host.DeleteMessage(s, last.ID)
+	if err != nil {
+		return s.SendEvent(s.newMessage([]byte("unable to delete: " +
+			err.Error())))
+	}
+	return nil
+}
+
+// edits the last message if the input is an s/old/new/ substitution. input
+// that only looks like one is left alone when there's nothing to edit
+func (s *Telnet) handleSubstitution(input []byte) (isSubstitution bool,
+	err error) {
+	match := substitution.FindStringSubmatch(strings.TrimSpace(
+		string(filterInput(input))))
+	if match == nil {
+		return false, nil
+	}
+	last, ok := s.lastMessage()
+	if !ok || !strings.Contains(last.Body, match[1]) {
+		return false, nil
+	}
+
+	return true, s.editCommand(strings.Replace(last.Body, match[1], match[2], 1))
+}
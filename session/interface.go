@@ -1,14 +1,94 @@
 package session
 
+import "time"
+
 // Session interface allows us to add other types later (like http)
 type Session interface {
+	// the channel being composed in
 	Channel() string
+	// every channel the session is a member of, including Channel()
+	Channels() []string
+	// usernames and user@host masks being ignored
 	IgnoreList() map[string]bool
 	Username() string
 	UsernameColor() string
-	GetMessages(usernameAvilable func(string) bool) (msg, event chan Message,
-		done chan error)
+	// address the session is connecting from, for bans and ignores
+	RemoteAddr() string
+	// why the session is away, empty when it isn't
+	Away() string
+	// when the session's user last did anything
+	LastActive() time.Time
+	GetMessages(host Host) (msg, event chan Message, done chan error)
 	SendMessage(Message) error
 	SendEvent(Message) error
+	// replaces an earlier message with a newer version of it (edited or
+	// deleted), matched by ID
+	UpdateMessage(Message) error
+	// called when the session has been removed from a channel by someone
+	// else, reason is meant to be shown to the user
+	Kicked(channel, reason string) error
 	Close() error
 }
+
+// Host is whatever a session is connected to (the chat server), it lets
+// sessions ask about the world outside of themselves
+type Host interface {
+	UsernameAvailable(username string) bool
+
+	// registered usernames need a password to log in with
+	Registered(username string) bool
+	Login(username, password string) bool
+	Register(sesh Session, password string) error
+	// ignore lists of registered users are kept between sessions
+	SavedIgnores(username string) []string
+	SaveIgnores(sesh Session) error
+	// errors are meant to be shown to the user
+	DirectMessage(sesh Session, username, body string) error
+	// changes to messages the session sent, errors are meant to be shown
+	// to the user
+	EditMessage(sesh Session, id uint64, body string) error
+	DeleteMessage(sesh Session, id uint64) error
+	// a message and every reply to it, oldest first
+	Thread(sesh Session, id uint64) ([]Message, error)
+	// when username was last active and whether they're online now, zero
+	// time if they've never been seen
+	LastSeen(username string) (seen time.Time, online bool)
+	ChannelMembers(channel string) []Session
+
+	// channels are kept track of by the host, errors returned from these
+	// are meant to be shown to the user
+	// password is only needed for password protected channels
+	JoinChannel(sesh Session, channel, password string) (ChannelInfo, error)
+	LeaveChannel(sesh Session, channel string)
+	ChannelInfo(channel string) ChannelInfo
+	ListChannels(sesh Session) []ChannelInfo
+	SetTopic(sesh Session, channel, topic string) error
+	SetChannelMode(sesh Session, channel, mode, arg string) error
+
+	// commands the host handles itself, sessions hand over any command they
+	// don't know. handled is false when there's no such command, errors are
+	// meant to be shown to the user
+	RunCommand(sesh Session, name string, args []string) (handled bool,
+		err error)
+	// every command for /help, including the ones sessions handle
+	// themselves (TelnetCommands) that RunCommand leaves alone
+	Commands() []CommandInfo
+}
+
+// a command for showing in help, name is typed after the /
+type CommandInfo struct {
+	Name  string `json:"name"`
+	Usage string `json:"usage,omitempty"`
+	Help  string `json:"help"`
+}
+
+// public details about a channel for showing to users
+type ChannelInfo struct {
+	Name    string `json:"name"`
+	Topic   string `json:"topic"`
+	Modes   string `json:"modes"`
+	Members int    `json:"members"`
+
+	// recent messages, oldest first, only filled in when joining
+	History []Message `json:"history,omitempty"`
+}
@@ -0,0 +1,146 @@
+package session
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// columns taken up by the member list, including its border
+	SIDEBAR_WIDTH = 20
+	// narrowest terminal we'll squeeze the member list into
+	MIN_SIDEBAR_TERM_WIDTH = 60
+
+	// reverse video and reset sequences for drawing the status bar
+	REVERSE_VIDEO = "\033[7m"
+	RESET         = "\033[0m"
+)
+
+// whether the member list should be drawn at the current size
+func (s *Telnet) sidebarVisible() bool {
+	return s.showSidebar && s.width >= MIN_SIDEBAR_TERM_WIDTH
+}
+
+// columns available for chat text
+func (s *Telnet) chatWidth() int {
+	if s.sidebarVisible() {
+		return s.width - SIDEBAR_WIDTH
+	}
+	return s.width
+}
+
+// cuts a plain string down to at most n bytes
+func truncate(str string, n int) string {
+	if n < 0 {
+		return ""
+	}
+	if len(str) > n {
+		return str[:n]
+	}
+	return str
+}
+
+// short away or idle marker for a member, there's no room for the reason
+func sidebarMarker(member Session) string {
+	switch {
+	case member.Away() != "":
+		return " (away)"
+	case time.Since(member.LastActive()) >= IDLE_AFTER:
+		return " (idle)"
+	}
+	return ""
+}
+
+// lines to draw in the member list, nil when the sidebar is hidden
+func (s *Telnet) sidebarCells() []string {
+	if !s.sidebarVisible() || s.host == nil {
+		return nil
+	}
+
+	members := s.host.ChannelMembers(s.Channel())
+	cells := []string{s.eventColor() + truncate("#"+s.Channel(), SIDEBAR_WIDTH-8) +
+		" (" + strconv.Itoa(len(members)) + ")"}
+	for _, member := range members {
+		marker := sidebarMarker(member)
+		cells = append(cells, s.usernameColor(member.UsernameColor())+
+			truncate(member.Username(), SIDEBAR_WIDTH-2-len(marker))+
+			s.eventColor()+marker)
+	}
+
+	// if everyone doesn't fit let the user know how many are missing
+	rows := s.chatRows()
+	if len(cells) > rows && rows > 1 {
+		more := len(cells) - (rows - 1)
+		cells = append(cells[:rows-1],
+			s.eventColor()+"+"+strconv.Itoa(more)+" more")
+	}
+	return cells
+}
+
+// draws the member list cell for a given row, to the right of the chat text
+func (s *Telnet) sidebarBytes(row int, cells []string) []byte {
+	cell := ""
+	if row-1 < len(cells) {
+		cell = cells[row-1]
+	}
+	return []byte("\033[" + strconv.Itoa(row) + ";" +
+		strconv.Itoa(s.chatWidth()+1) + "H" + s.eventColor() + "|" + cell +
+		s.messageColor())
+}
+
+// topic of the current channel for the status bar, empty when the status bar
+// is hidden so we don't bother the host
+func (s *Telnet) channelTopic() string {
+	if !s.showStatus || s.host == nil {
+		return ""
+	}
+	return s.host.ChannelInfo(s.Channel()).Topic
+}
+
+// draws the status bar just above the compose window, expects bufferMtx to
+// be held
+func (s *Telnet) statusBarBytes(topic string) []byte {
+	status := " " + s.channelTabs()
+	if topic != "" {
+		status += " | " + topic
+	}
+	status += " | connected " +
+		time.Since(s.connected).Truncate(time.Second).String()
+
+	// fill the whole row so the reverse video reads as a bar
+	status = truncate(status, s.width)
+	status += strings.Repeat(" ", s.width-len(status))
+
+	return []byte("\033[" + strconv.Itoa(s.height-1) + ";0H\033[K" +
+		REVERSE_VIDEO + status + RESET + s.messageColor())
+}
+
+// handles /layout [sidebar|status], with no arguments both panes are toggled
+// together
+func (s *Telnet) layoutCommand(args []string) (err error) {
+	pane := ""
+	if len(args) > 0 {
+		pane = strings.TrimSpace(args[0])
+	}
+
+	s.bufferMtx.Lock()
+	switch pane {
+	case "":
+		show := !(s.showSidebar || s.showStatus)
+		s.showSidebar = show
+		s.showStatus = show
+	case "sidebar":
+		s.showSidebar = !s.showSidebar
+	case "status":
+		s.showStatus = !s.showStatus
+	default:
+		s.bufferMtx.Unlock()
+		return s.SendEvent(s.newMessage([]byte(layoutHelp)))
+	}
+
+	// less room for chat means the buffer may wrap to fewer rows
+	s.clampScroll()
+	s.bufferMtx.Unlock()
+	return nil
+}
@@ -0,0 +1,105 @@
+package session
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// most links /links lists
+	MAX_LINKS = 10
+
+	// OSC 8 hyperlinks, terminals that support them make links clickable
+	HYPERLINK_START = "\033]8;;"
+	HYPERLINK_END   = "\033\\"
+)
+
+var (
+	// only web links are picked out, anything else stays plain text
+	linkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+)
+
+// a link found in a message body. the title is filled in by the server once
+// it's been looked up, if it ever is
+type Link struct {
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+}
+
+// where links are in text as start and end offsets. punctuation at the end
+// is more likely to be part of the sentence than the link
+func linkRanges(text string) [][]int {
+	ranges := linkPattern.FindAllStringIndex(text, -1)
+	for _, r := range ranges {
+		for r[1] > r[0] && strings.IndexByte(".,;:!?)'*`", text[r[1]-1]) >= 0 {
+			r[1]--
+		}
+	}
+	return ranges
+}
+
+// every link in a message body, in the order they appear
+func FindLinks(body string) (links []Link) {
+	for _, r := range linkRanges(body) {
+		links = append(links, Link{URL: body[r[0]:r[1]]})
+	}
+	return links
+}
+
+// whether the terminal is likely to understand OSC 8 hyperlinks. there's no
+// way to ask so we go by truecolor support, which arrived in terminals around
+// the same time
+func (s *Telnet) hyperlinks() bool {
+	return s.ansi && s.depth == TRUECOLOR
+}
+
+// draws a link underlined, and clickable where the terminal allows it
+func (s *Telnet) renderLink(url, color string) string {
+	if !s.ansi {
+		return url
+	}
+	link := UNDERLINE + url + RESET + color
+	if s.hyperlinks() {
+		link = HYPERLINK_START + url + HYPERLINK_END + link +
+			HYPERLINK_START + HYPERLINK_END
+	}
+	return link
+}
+
+// handles /links, listing the most recent links in the current channel
+func (s *Telnet) linksCommand() (err error) {
+	var lines []string
+	s.bufferMtx.Lock()
+	for _, entry := range s.buffers[s.Chan] {
+		if entry.event || entry.msg.Deleted {
+			continue
+		}
+		for _, link := range entry.msg.Links {
+			line := "[" + s.formatTime(entry.msg.T) + "] " +
+				entry.msg.From.Username() + ": " + link.URL
+			if link.Title != "" {
+				line += " - " + link.Title
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) >= MAX_LINKS {
+			break
+		}
+	}
+	s.bufferMtx.Unlock()
+	if len(lines) > MAX_LINKS {
+		lines = lines[:MAX_LINKS]
+	}
+
+	if len(lines) == 0 {
+		return s.SendEvent(s.newMessage([]byte("no links in #" + s.Channel())))
+	}
+	// oldest first so the newest ends up nearest the prompt
+	for i := len(lines) - 1; i >= 0; i-- {
+		err = s.SendEvent(s.newMessage([]byte(lines[i])))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
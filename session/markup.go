@@ -0,0 +1,195 @@
+package session
+
+import (
+	"html"
+	"strings"
+)
+
+// how a span of a message's body is drawn
+type SpanStyle string
+
+const (
+	STYLE_PLAIN     SpanStyle = ""
+	STYLE_BOLD      SpanStyle = "bold"      // *bold*
+	STYLE_UNDERLINE SpanStyle = "underline" // _underline_
+	STYLE_CODE      SpanStyle = "code"      // `code`, nothing inside is markup
+	STYLE_LINK      SpanStyle = "link"      // web links, see links.go
+
+	// ansi attributes for the styles telnet draws with escape sequences,
+	// code is drawn in the theme's event color instead
+	BOLD      = "\033[1m"
+	UNDERLINE = "\033[4m"
+)
+
+var (
+	// characters that wrap text in each style
+	markers = map[byte]SpanStyle{
+		'*': STYLE_BOLD,
+		'_': STYLE_UNDERLINE,
+		'`': STYLE_CODE,
+	}
+
+	// tags each style is wrapped in for html
+	htmlTags = map[SpanStyle]string{
+		STYLE_BOLD:      "strong",
+		STYLE_UNDERLINE: "u",
+		STYLE_CODE:      "code",
+	}
+)
+
+// a run of a message's body drawn in a single style, markers not included
+type Span struct {
+	Text  string    `json:"text"`
+	Style SpanStyle `json:"style,omitempty"`
+}
+
+func isWordByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
+}
+
+// whether offset i falls inside one of the link ranges
+func inLink(links [][]int, i int) bool {
+	for _, r := range links {
+		if i >= r[0] && i < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// where the marker opening a span at text[i] is closed, -1 if it isn't.
+// markers only count at the edges of words so snake_case and 2*3*4 are left
+// alone, and spans never run across lines or end inside links
+func closingMarker(text string, i int, links [][]int) int {
+	marker := text[i]
+	if i > 0 && isWordByte(text[i-1]) {
+		return -1
+	}
+	if i+1 >= len(text) || strings.IndexByte(" \n", text[i+1]) >= 0 ||
+		text[i+1] == marker {
+		return -1
+	}
+
+	for j := i + 2; j < len(text) && text[j] != '\n'; j++ {
+		if text[j] == marker && text[j-1] != ' ' && !inLink(links, j) &&
+			(j+1 == len(text) || !isWordByte(text[j+1])) {
+			return j
+		}
+	}
+	return -1
+}
+
+// splits text into styled spans. markers without a partner are left as they
+// are and links are spans of their own, markup can't break them up
+func ParseMarkup(text string) (spans []Span) {
+	links := linkRanges(text)
+	plain := 0
+	for i := 0; i < len(text); i++ {
+		// links caught up in a styled span are part of that span
+		for len(links) > 0 && links[0][0] < i {
+			links = links[1:]
+		}
+		if len(links) > 0 && i == links[0][0] {
+			if i > plain {
+				spans = append(spans, Span{Text: text[plain:i]})
+			}
+			spans = append(spans, Span{Text: text[i:links[0][1]], Style: STYLE_LINK})
+			i, plain = links[0][1]-1, links[0][1]
+			links = links[1:]
+			continue
+		}
+
+		style, ok := markers[text[i]]
+		if !ok {
+			continue
+		}
+		end := closingMarker(text, i, links)
+		if end < 0 {
+			continue
+		}
+
+		if i > plain {
+			spans = append(spans, Span{Text: text[plain:i]})
+		}
+		spans = append(spans, Span{Text: text[i+1 : end], Style: style})
+		i, plain = end, end+1
+	}
+	if plain < len(text) {
+		spans = append(spans, Span{Text: text[plain:]})
+	}
+	return spans
+}
+
+// drops control characters so text can't move the cursor or start escape
+// sequences wherever it's drawn, line breaks are left to the caller
+func safeText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 32 && r != '\n' || r == 127 {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// the message's body split into styled spans, lines are kept apart by \n
+func (m Message) Spans() []Span {
+	return ParseMarkup(safeText(strings.Join(m.Lines(), "\n")))
+}
+
+// the message's body with its markup taken out, for clients that can't draw
+// styles
+func (m Message) PlainText() string {
+	var plain strings.Builder
+	for _, span := range m.Spans() {
+		plain.WriteString(span.Text)
+	}
+	return plain.String()
+}
+
+// the message's body as html, for web clients
+func (m Message) HTML() string {
+	var rendered strings.Builder
+	for _, span := range m.Spans() {
+		text := strings.Replace(html.EscapeString(span.Text), "\n", "<br>", -1)
+		if span.Style == STYLE_LINK {
+			// links only ever start with http:// or https://
+			rendered.WriteString("<a href=\"" + text + "\">" + text + "</a>")
+			continue
+		}
+		tag, ok := htmlTags[span.Style]
+		if !ok {
+			rendered.WriteString(text)
+			continue
+		}
+		rendered.WriteString("<" + tag + ">" + text + "</" + tag + ">")
+	}
+	return rendered.String()
+}
+
+// draws a line of a message with its markup as ansi attributes, color is
+// what to go back to after each styled span. clients without ansi just get
+// the text
+func (s *Telnet) renderMarkup(line, color string) string {
+	var rendered strings.Builder
+	for _, span := range ParseMarkup(safeText(line)) {
+		if span.Style == STYLE_PLAIN || !s.ansi {
+			rendered.WriteString(span.Text)
+			continue
+		}
+		if span.Style == STYLE_LINK {
+			rendered.WriteString(s.renderLink(span.Text, color))
+			continue
+		}
+
+		switch span.Style {
+		case STYLE_BOLD:
+			rendered.WriteString(BOLD)
+		case STYLE_UNDERLINE:
+			rendered.WriteString(UNDERLINE)
+		case STYLE_CODE:
+			rendered.WriteString(s.eventColor())
+		}
+		rendered.WriteString(span.Text + RESET + color)
+	}
+	return rendered.String()
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMarkup(t *testing.T) {
+	spans := ParseMarkup("a *bold* and _underlined_ `x := *y*` end")
+	expected := []Span{{Text: "a "}, {Text: "bold", Style: STYLE_BOLD},
+		{Text: " and "}, {Text: "underlined", Style: STYLE_UNDERLINE},
+		{Text: " "}, {Text: "x := *y*", Style: STYLE_CODE}, {Text: " end"}}
+	if len(spans) != len(expected) {
+		t.Fatalf("incorrect spans %+v", spans)
+	}
+	for i := range spans {
+		if spans[i] != expected[i] {
+			t.Errorf("incorrect span %d %+v", i, spans[i])
+		}
+	}
+}
+
+func TestParseMarkupLeavesWordsAlone(t *testing.T) {
+	for _, text := range []string{"snake_case_name", "2*3*4", "* not bold *",
+		"**", "*unclosed", "*across\nlines*"} {
+		spans := ParseMarkup(text)
+		if len(spans) != 1 || spans[0].Style != STYLE_PLAIN {
+			t.Errorf("markup found in %q %+v", text, spans)
+		}
+	}
+}
+
+func TestMarkupCantInjectEscapes(t *testing.T) {
+	msg := NewMessage("\033[2J *hi\033]0;pwned\007* <b>", "testchannel", nil)
+	if plain := msg.PlainText(); plain != "[2J hi]0;pwned <b>" {
+		t.Errorf("control characters not dropped %q", plain)
+	}
+	if rendered := msg.HTML(); rendered != "[2J <strong>hi]0;pwned</strong> &lt;b&gt;" {
+		t.Errorf("incorrect html %q", rendered)
+	}
+
+	tel := createTelnet()
+	tel.ansi = true
+	line := tel.renderMarkup(msg.Body, MESSAGE_COLOR)
+	if strings.Contains(line, "\033[2J") || strings.Contains(line, "\007") ||
+		!strings.Contains(line, BOLD+"hi") {
+		t.Errorf("incorrect rendering %q", line)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	links := FindLinks("see https://example.com/a_b_c, or (http://x.org/_y_).")
+	if len(links) != 2 || links[0].URL != "https://example.com/a_b_c" ||
+		links[1].URL != "http://x.org/_y_" {
+		t.Errorf("incorrect links %+v", links)
+	}
+
+	// markup can't break links up
+	spans := ParseMarkup("_go_ http://x.org/_y_ *now*")
+	if len(spans) != 5 || spans[2].Style != STYLE_LINK ||
+		spans[2].Text != "http://x.org/_y_" || spans[4].Style != STYLE_BOLD {
+		t.Errorf("incorrect spans %+v", spans)
+	}
+
+	msg := NewMessage("http://x.org/?a=1&b=2", "testchannel", nil)
+	if rendered := msg.HTML(); rendered != `<a href="http://x.org/?a=1&amp;b=2">`+
+		`http://x.org/?a=1&amp;b=2</a>` {
+		t.Errorf("incorrect html %q", rendered)
+	}
+
+	tel := createTelnet()
+	tel.ansi, tel.depth = true, TRUECOLOR
+	line := tel.renderMarkup(msg.Body, MESSAGE_COLOR)
+	if !strings.HasPrefix(line, HYPERLINK_START+msg.Body+HYPERLINK_END+UNDERLINE) {
+		t.Errorf("link not drawn as a hyperlink %q", line)
+	}
+}
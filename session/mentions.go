@@ -0,0 +1,140 @@
+package session
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// how many mentions are kept around for /mentions
+	MAX_MENTIONS = 50
+
+	// terminal bell, rung when someone mentions you
+	BELL = "\a"
+)
+
+var (
+	highlightHelp = "usage: /highlight [word], highlighting a word again " +
+		"stops highlighting it"
+)
+
+// whether word shows up in text on its own rather than as part of a longer
+// word, ignoring case. "@dan," mentions dan but "dangerous" doesn't
+func containsWord(text, word string) bool {
+	if word == "" {
+		return false
+	}
+	text, word = strings.ToLower(text), strings.ToLower(word)
+
+	isWordChar := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+	}
+	for start := 0; ; {
+		idx := strings.Index(text[start:], word)
+		if idx < 0 {
+			return false
+		}
+		idx += start
+		end := idx + len(word)
+
+		before := idx == 0 || !isWordChar(rune(text[idx-1]))
+		after := end == len(text) || !isWordChar(rune(text[end]))
+		if before && after {
+			return true
+		}
+		start = idx + 1
+	}
+}
+
+// whether a message is aimed at the session's user, either by name, a
+// highlight word or as a direct message
+func (s *Telnet) mentions(msg Message) bool {
+	if msg.From == nil || msg.From.Username() == s.Name {
+		return false
+	}
+	if msg.To == s.Name || containsWord(msg.Body, s.Name) {
+		return true
+	}
+
+	s.bufferMtx.Lock()
+	defer s.bufferMtx.Unlock()
+	for _, word := range s.highlights {
+		if containsWord(msg.Body, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// gets the user's attention for a mention, mentions in channels they aren't
+// looking at are kept for /mentions
+func (s *Telnet) notifyMention(msg Message) error {
+	if msg.Channel != s.Channel() {
+		s.bufferMtx.Lock()
+		s.mentionList = append(s.mentionList, msg)
+		if len(s.mentionList) > MAX_MENTIONS {
+			s.mentionList = s.mentionList[len(s.mentionList)-MAX_MENTIONS:]
+		}
+		s.bufferMtx.Unlock()
+	}
+
+	alert := BELL
+	if s.ansi {
+		// terminals show the window title even when they're in the
+		// background
+		alert += "\033]0;" + msg.From.Username() + " mentioned you in #" +
+			msg.Channel + BELL
+	}
+	return s.raw([]byte(alert))
+}
+
+// handles /highlight [word], with no arguments the words are listed
+func (s *Telnet) highlightCommand(word string) (err error) {
+	s.bufferMtx.Lock()
+	if word == "" {
+		words := strings.Join(s.highlights, ", ")
+		s.bufferMtx.Unlock()
+		if words == "" {
+			return s.SendEvent(s.newMessage([]byte(highlightHelp)))
+		}
+		return s.SendEvent(s.newMessage([]byte("highlighting: " + words)))
+	}
+
+	remaining := s.highlights[:0]
+	for _, existing := range s.highlights {
+		if !strings.EqualFold(existing, word) {
+			remaining = append(remaining, existing)
+		}
+	}
+	removed := len(remaining) != len(s.highlights)
+	s.highlights = remaining
+	if !removed {
+		s.highlights = append(s.highlights, word)
+	}
+	s.bufferMtx.Unlock()
+
+	if removed {
+		return s.SendEvent(s.newMessage([]byte("no longer highlighting " + word)))
+	}
+	return s.SendEvent(s.newMessage([]byte("now highlighting " + word)))
+}
+
+// handles /mentions, listing recent mentions from other channels
+func (s *Telnet) mentionsCommand() (err error) {
+	s.bufferMtx.Lock()
+	mentions := append([]Message(nil), s.mentionList...)
+	s.bufferMtx.Unlock()
+
+	if len(mentions) == 0 {
+		return s.SendEvent(s.newMessage([]byte("no mentions")))
+	}
+	for _, msg := range mentions {
+		err = s.SendEvent(s.newMessage([]byte("[" + s.timestamp(msg.T) +
+			"] #" + msg.Channel + " " + msg.From.Username() + ": " +
+			displayBody(msg.Body))))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,13 +1,50 @@
 package session
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// what sort of message something is, sessions draw each kind differently
+type MessageKind string
+
+const (
+	// someone talking in a channel
+	KIND_NORMAL MessageKind = "normal"
+	// /me, drawn as "* user waves"
+	KIND_ACTION MessageKind = "action"
+	// automated messages that shouldn't be mistaken for someone talking
+	KIND_NOTICE MessageKind = "notice"
+	// from the server itself
+	KIND_SYSTEM MessageKind = "system"
+	// straight to one user, see Message.To
+	KIND_DM MessageKind = "dm"
+)
 
 // json deocoding/encoding supported even though we dont' use it
 type Message struct {
-	T       time.Time `json:"timestamp"`
-	From    Session   `json:"session"`
-	Body    string    `json:"body"`
-	Channel string    `json:"channel"`
+	// assigned by the server, unique and increasing. zero until it's been
+	// through the server
+	ID      uint64      `json:"id,omitempty"`
+	T       time.Time   `json:"timestamp"`
+	From    Session     `json:"session"`
+	Body    string      `json:"body"`
+	Channel string      `json:"channel"`
+	Kind    MessageKind `json:"kind"`
+	// recipient of a direct message, empty for channel messages
+	To string `json:"to,omitempty"`
+	// id of the message this one is replying to, zero if it isn't a reply
+	ReplyTo uint64 `json:"reply_to,omitempty"`
+
+	// changes made after the message was sent, deleted messages have no
+	// body left
+	Edited  bool `json:"edited,omitempty"`
+	Deleted bool `json:"deleted,omitempty"`
+	// links in the body, see FindLinks. kept up to date by the server
+	Links []Link `json:"links,omitempty"`
+	// reaction shortcodes and who reacted with each, treat as read only
+	// since copies of a message share it
+	Reactions map[string][]string `json:"reactions,omitempty"`
 }
 
 // helper method to generate message
@@ -17,5 +54,16 @@ func NewMessage(body, channel string, from Session) Message {
 		From:    from,
 		T:       time.Now(),
 		Channel: channel,
+		Kind:    KIND_NORMAL,
+	}
+}
+
+// the lines of a message's body without line endings, multi-line messages
+// separate their lines with \n
+func (m Message) Lines() []string {
+	lines := strings.Split(strings.TrimRight(m.Body, "\r\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], "\r")
 	}
+	return lines
 }
@@ -0,0 +1,251 @@
+package session
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"time"
+)
+
+// a single telnet command pulled out of the input stream, for subnegotiations
+// (SB) payload holds everything between the option and IAC SE
+type telnetCommand struct {
+	verb    byte
+	option  byte
+	payload []byte
+}
+
+// separates telnet commands from the data the user actually typed
+func parseTelnetCommands(b []byte) (cmds []telnetCommand, data []byte) {
+	for i := 0; i < len(b); i++ {
+		if b[i] != IAC {
+			data = append(data, b[i])
+			continue
+		}
+
+		// a lone IAC at the end of a read, nothing we can do with it
+		if i+1 >= len(b) {
+			break
+		}
+
+		switch b[i+1] {
+		case IAC:
+			// escaped 255 data byte
+			data = append(data, IAC)
+			i++
+		case WILL, WONT, DO, DONT:
+			if i+2 < len(b) {
+				cmds = append(cmds, telnetCommand{verb: b[i+1], option: b[i+2]})
+			}
+			i += 2
+		case SB:
+			end := bytes.Index(b[i+2:], []byte{IAC, SE})
+			if end < 0 {
+				// unterminated subnegotiation, drop the rest
+				i = len(b)
+				continue
+			}
+
+			sub := b[i+2 : i+2+end]
+			if len(sub) > 0 {
+				cmds = append(cmds, telnetCommand{verb: SB, option: sub[0],
+					payload: bytes.Replace(sub[1:], []byte{IAC, IAC},
+						[]byte{IAC}, -1)})
+			}
+			i += 2 + end + 1
+		default:
+			// two byte commands (NOP, GA, etc) we don't care about
+			i++
+		}
+	}
+	return cmds, data
+}
+
+// pulls variables out of a NEW-ENVIRON IS/INFO payload (RFC 1572)
+func parseEnviron(payload []byte) map[string]string {
+	environ := make(map[string]string)
+	if len(payload) == 0 ||
+		(payload[0] != ENV_IS && payload[0] != ENV_INFO) {
+		return environ
+	}
+
+	var name, value []byte
+	inValue := false
+	haveName := false
+	flush := func() {
+		if haveName {
+			environ[string(name)] = string(value)
+		}
+		name, value = nil, nil
+		inValue, haveName = false, false
+	}
+
+	for i := 1; i < len(payload); i++ {
+		b := payload[i]
+		switch b {
+		case ENV_VAR, ENV_USERVAR:
+			flush()
+			haveName = true
+			continue
+		case ENV_VALUE:
+			inValue = true
+			continue
+		case ENV_ESC:
+			// the next byte is literal
+			if i+1 >= len(payload) {
+				continue
+			}
+			i++
+			b = payload[i]
+		}
+
+		if inValue {
+			value = append(value, b)
+		} else {
+			name = append(name, b)
+		}
+	}
+	flush()
+	return environ
+}
+
+// clients whose terminal type means they can't handle escape sequences
+func isDumbTerminal(termType string) bool {
+	switch strings.ToLower(termType) {
+	case "", "dumb", "unknown":
+		return true
+	}
+	return false
+}
+
+// environment variables we ask clients for
+var requestedEnviron = []string{"COLORTERM", "TZ"}
+
+// asks the client about its window size, terminal type and environment.
+// clients that don't speak telnet (like netcat) never answer so we give up
+// after NEGOTIATION_TIMEOUT and treat them as plain line mode clients
+func (s *Telnet) negotiate() error {
+	s.raw([]byte{IAC, DO, NAWS, IAC, DO, TTYPE, IAC, DO, NEW_ENVIRON})
+
+	s.conn.SetReadDeadline(time.Now().Add(NEGOTIATION_TIMEOUT))
+	defer s.conn.SetReadDeadline(time.Time{})
+
+	nawsDone, ttypeDone, environDone := false, false, false
+	b := make([]byte, EXPECTED_MSG_SIZE)
+	for !nawsDone || !ttypeDone || !environDone {
+		n, err := s.conn.Read(b)
+		if err != nil {
+			// running out of time just means they aren't going to answer
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				break
+			}
+			return err
+		}
+
+		cmds, _ := parseTelnetCommands(b[:n])
+		for _, cmd := range cmds {
+			switch {
+			case cmd.verb == WILL && cmd.option == NAWS:
+				s.richClient = true
+				nawsDone = true
+			case cmd.verb == WONT && cmd.option == NAWS:
+				s.richClient = false
+				nawsDone = true
+			case cmd.verb == SB && cmd.option == NAWS:
+				s.resize(cmd.payload)
+			case cmd.verb == WILL && cmd.option == TTYPE:
+				// they're willing, now ask what they are
+				s.raw([]byte{IAC, SB, TTYPE, TTYPE_SEND, IAC, SE})
+			case cmd.verb == WONT && cmd.option == TTYPE:
+				ttypeDone = true
+			case cmd.verb == SB && cmd.option == TTYPE:
+				if len(cmd.payload) > 0 && cmd.payload[0] == TTYPE_IS {
+					s.termType = string(cmd.payload[1:])
+				}
+				ttypeDone = true
+			case cmd.verb == WILL && cmd.option == NEW_ENVIRON:
+				// ask for the variables we care about, both as well
+				// known and user variables since clients differ
+				request := []byte{IAC, SB, NEW_ENVIRON, ENV_SEND}
+				for _, name := range requestedEnviron {
+					request = append(request, ENV_VAR)
+					request = append(request, name...)
+					request = append(request, ENV_USERVAR)
+					request = append(request, name...)
+				}
+				s.raw(append(request, IAC, SE))
+			case cmd.verb == WONT && cmd.option == NEW_ENVIRON:
+				environDone = true
+			case cmd.verb == SB && cmd.option == NEW_ENVIRON:
+				s.setEnviron(cmd.payload)
+				environDone = true
+			}
+		}
+	}
+
+	// anything that can size its window is assumed to understand escapes
+	// unless it tells us otherwise
+	if s.termType != "" && isDumbTerminal(s.termType) {
+		s.richClient = false
+	}
+	s.ansi = s.richClient || !isDumbTerminal(s.termType)
+
+	s.depth = MONOCHROME
+	if s.ansi {
+		s.depth = detectColorDepth(s.termType, s.environ)
+	}
+
+	// show times in the client's zone if they told us what it is
+	s.setTimezone(s.environ["TZ"])
+	return nil
+}
+
+// records variables from a NEW-ENVIRON subnegotiation, empty values don't
+// overwrite what we already know
+func (s *Telnet) setEnviron(payload []byte) {
+	for name, value := range parseEnviron(payload) {
+		if value != "" {
+			s.environ[name] = value
+		}
+	}
+}
+
+// resize virtual terminal info when we get naws info
+func (s *Telnet) resize(payload []byte) {
+	if len(payload) < 4 {
+		return
+	}
+
+	// sizes are sent as 16 bit big endian values
+	s.bufferMtx.Lock()
+	s.width = int(payload[0])<<8 | int(payload[1])
+	s.height = int(payload[2])<<8 | int(payload[3])
+
+	// the buffer is rewrapped to the new width on redraw, which may leave us
+	// scrolled further back than there are rows for
+	s.clampScroll()
+	s.bufferMtx.Unlock()
+}
+
+// handles any telnet commands mixed in with input, returning what's left for
+// us to treat as user input
+func (s *Telnet) handleTelnetCommands(b []byte) (input []byte, err error) {
+	cmds, input := parseTelnetCommands(b)
+	for _, cmd := range cmds {
+		// clients may tell us about environment changes at any time
+		if cmd.verb == SB && cmd.option == NEW_ENVIRON {
+			s.setEnviron(cmd.payload)
+		}
+
+		if cmd.verb == SB && cmd.option == NAWS && s.richClient {
+			s.resize(cmd.payload)
+
+			// redraw based on new size info
+			err = s.redrawAll()
+			if err != nil {
+				return input, err
+			}
+		}
+	}
+	return input, nil
+}
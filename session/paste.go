@@ -0,0 +1,86 @@
+package session
+
+import "strings"
+
+const (
+	// most we'll collect into a single multi-line message
+	MAX_PASTE_SIZE = 8192
+
+	// a line of its own that finishes /paste mode
+	PASTE_END = "."
+)
+
+var (
+	pasteHelp = "pasting, everything you send is one message until a line " +
+		"with just " + PASTE_END
+)
+
+// splits input into lines, dropping the line ending it finished with
+func inputLines(input []byte) []string {
+	filtered := filterInput(append([]byte(nil), input...))
+	return Message{Body: string(filtered)}.Lines()
+}
+
+// whether input finishes with a line ending, reads that fill the buffer
+// without one are part of something bigger
+func endsLine(input []byte) bool {
+	if len(input) == 0 {
+		return true
+	}
+	last := input[len(input)-1]
+	// some clients send CR NUL for enter
+	return last == '\n' || last == '\r' || last == 0
+}
+
+// turns lines into a single message, leaving off blank lines at either end
+func (s *Telnet) pastedMessage(lines []string) Message {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return s.newMessage([]byte(strings.Join(lines, "\n")))
+}
+
+// collects multi-line messages, typed out in /paste mode or pasted in bulk.
+// isPaste says the input was used up here, pasted only has a body once a
+// whole message has been collected
+func (s *Telnet) handlePaste(input []byte) (pasted Message, isPaste bool,
+	err error) {
+	lines := inputLines(input)
+	if !s.pasting {
+		if len(lines) == 1 && strings.TrimSpace(lines[0]) == "/paste" {
+			s.pasting, s.paste, s.pasteSize = true, nil, 0
+			return pasted, true, s.SendEvent(s.newMessage([]byte(pasteHelp)))
+		}
+
+		// nobody types several lines at once, they were pasted
+		if len(lines) < 2 {
+			return pasted, false, nil
+		}
+		return s.pastedMessage(lines), true, nil
+	}
+
+	for _, line := range lines {
+		if line == PASTE_END {
+			s.pasting = false
+			pasted = s.pastedMessage(s.paste)
+			s.paste = nil
+			if pasted.Body == "" {
+				return pasted, true, s.SendEvent(s.newMessage(
+					[]byte("nothing pasted")))
+			}
+			return pasted, true, nil
+		}
+		s.paste = append(s.paste, line)
+		s.pasteSize += len(line) + 1
+	}
+
+	if s.pasteSize > MAX_PASTE_SIZE {
+		s.pasting, s.paste = false, nil
+		return pasted, true, s.SendEvent(s.newMessage([]byte(
+			"paste too long, nothing was sent")))
+	}
+	return pasted, true, nil
+}
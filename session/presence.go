@@ -0,0 +1,104 @@
+package session
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// how long without input before someone is shown as idle
+	IDLE_AFTER = 10 * time.Minute
+)
+
+var (
+	seenHelp = "usage: /seen [user]"
+)
+
+// why the session is away, empty when it isn't
+func (s *Telnet) Away() string {
+	s.bufferMtx.Lock()
+	defer s.bufferMtx.Unlock()
+	return s.away
+}
+
+// when the user last typed anything
+func (s *Telnet) LastActive() time.Time {
+	s.bufferMtx.Lock()
+	defer s.bufferMtx.Unlock()
+	return s.lastActive
+}
+
+// records that the user just did something
+func (s *Telnet) touch() {
+	s.bufferMtx.Lock()
+	s.lastActive = time.Now()
+	s.bufferMtx.Unlock()
+}
+
+// rounds durations off to something readable
+func describeAgo(d time.Duration) string {
+	if d < time.Minute {
+		return d.Truncate(time.Second).String()
+	}
+	return d.Truncate(time.Minute).String()
+}
+
+// away and idle markers for a session, empty when they're around
+func Presence(sesh Session) string {
+	marker := ""
+	if away := sesh.Away(); away != "" {
+		marker += " [away: " + away + "]"
+	}
+	if idle := time.Since(sesh.LastActive()); idle >= IDLE_AFTER {
+		marker += " [idle " + describeAgo(idle) + "]"
+	}
+	return marker
+}
+
+// handles /away [reason] and /back
+func (s *Telnet) awayCommand(reason string) (err error) {
+	if reason == "" {
+		reason = "away"
+	}
+	s.bufferMtx.Lock()
+	s.away = reason
+	s.bufferMtx.Unlock()
+	return s.SendEvent(s.newMessage([]byte("you're marked as away (" +
+		reason + "), /back when you return")))
+}
+
+func (s *Telnet) backCommand() (err error) {
+	s.bufferMtx.Lock()
+	s.away = ""
+	s.bufferMtx.Unlock()
+	return s.SendEvent(s.newMessage([]byte("welcome back")))
+}
+
+// handles /seen [user]
+func (s *Telnet) seenCommand(username string) (err error) {
+	if username == "" {
+		return s.SendEvent(s.newMessage([]byte(seenHelp)))
+	}
+
+	seen, online := s.host.LastSeen(username)
+	var description string
+	switch {
+	case online:
+		description = username + " is online, last active " +
+			describeAgo(time.Since(seen)) + " ago"
+	case seen.IsZero():
+		description = "haven't seen " + username
+	default:
+		s.bufferMtx.Lock()
+		when := seen.In(s.location).Format("2006-01-02 " + s.clockFormat)
+		s.bufferMtx.Unlock()
+		description = username + " was last seen " +
+			describeAgo(time.Since(seen)) + " ago (" + when + ")"
+	}
+	return s.SendEvent(s.newMessage([]byte(description)))
+}
+
+// trims the command name off of input, for commands that take free text
+func commandText(cmd []string) string {
+	return strings.TrimSpace(strings.Join(cmd[1:], " "))
+}
@@ -0,0 +1,23 @@
+package session
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tallies of a message's reactions like "[+1 2] [eyes 1]", sorted so lines
+// don't shuffle around as people react
+func describeReactions(reactions map[string][]string) string {
+	codes := make([]string, 0, len(reactions))
+	for code := range reactions {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	tallies := make([]string, len(codes))
+	for i, code := range codes {
+		tallies[i] = "[" + code + " " + strconv.Itoa(len(reactions[code])) + "]"
+	}
+	return strings.Join(tallies, " ")
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -17,55 +18,59 @@ const (
 	SB   = byte(250) //[S]equence [B]egin
 	SE   = byte(240) //[S]equence [E]end
 
+	// whoever has ECHO does the echoing, used to hide passwords
+	ECHO = byte(1)
+
 	//special command for getting term size
 	NAWS = byte(31) //[N]egotiate [A]bout [W]indow [S]ize
 
+	// options for asking the client what kind of terminal it is
+	TTYPE      = byte(24) //[T]erminal [TYPE]
+	TTYPE_IS   = byte(0)
+	TTYPE_SEND = byte(1)
+
+	// options for asking the client about its environment variables
+	NEW_ENVIRON = byte(39)
+	ENV_IS      = byte(0)
+	ENV_SEND    = byte(1)
+	ENV_INFO    = byte(2)
+	ENV_VAR     = byte(0)
+	ENV_VALUE   = byte(1)
+	ENV_ESC     = byte(2)
+	ENV_USERVAR = byte(3)
+
+	// how long we wait for a client to answer option negotiation before
+	// assuming it doesn't speak telnet at all (netcat, scripts)
+	NEGOTIATION_TIMEOUT = 2 * time.Second
+
 	// default buffer size for reading messages from connection
 	EXPECTED_MSG_SIZE = 128
+
+	// escape sequences terminals send for the page up/down keys, used for
+	// scrolling back through the chat buffer
+	PAGE_UP   = "\033[5~"
+	PAGE_DOWN = "\033[6~"
 )
 
 var (
-	// default term color for messages (white)
-	MESSAGE_COLOR = "\033[1;37m"
+	// default term color for messages (white), see color.go for themes
+	MESSAGE_COLOR = THEMES["default"].Message.Sequence(ANSI_16)
 	// default term color for events (light gray)
-	EVENT_COLOR = "\033[1;30m"
+	EVENT_COLOR = THEMES["default"].Event.Sequence(ANSI_16)
 
 	// ensure Telnet adheres to the Session interface
 	_ Session = (*Telnet)(nil)
 
-	// mapping of plain text colors to terminal escape sequence colors
-	TELNET_USERNAME_COLORS = map[string]string{
-		"red":     "\033[0;31m",
-		"orange":  "\033[1;31m",
-		"green":   "\033[0;32m",
-		"lime":    "\033[1;32m",
-		"brown":   "\033[0;33m",
-		"yellow":  "\033[1;33m",
-		"blue":    "\033[0;34m",
-		"indigo":  "\033[1;34m",
-		"purple":  "\033[0;35m",
-		"fuschia": "\033[1;35m",
-		"cyan":    "\033[0;36m",
-		"aqua":    "\033[1;36m",
-	}
-
-	// in the event we get a color we don't recognie, just use fuschia
-	DEFAULT_TELNET_USERNAME_COLOR = TELNET_USERNAME_COLORS["fuschia"]
-
-	// predefined strings for command help in telnet session
-	commandHelp = "available commands: /help, /join [channel], /part, /ignore [user]"
-	joinHelp    = "usage: /join [channel]"
-	ignoreHelp  = "usage: /ignore [user]"
-)
+	// predefined strings for command help in telnet session, see
+	// commands.go for the full list
+	joinHelp   = "usage: /join [channel] [password]"
+	ignoreHelp = "usage: /ignore [user|user@host mask]"
+	scrollHelp = "usage: /scroll [up|down|end] [lines]"
+	layoutHelp = "usage: /layout [sidebar|status]"
 
-// translates plain text color to an escape sequence
-func getTelnetColor(color string) string {
-	if telnetColor, ok := TELNET_USERNAME_COLORS[color]; ok {
-		return telnetColor
-	} else {
-		return DEFAULT_TELNET_USERNAME_COLOR
-	}
-}
+	// shown in place of the newest line while scrolled back through history
+	scrollIndicator = "-- more below --"
+)
 
 type Telnet struct {
 	// make name and channel json decodeable for other transports
@@ -76,71 +81,185 @@ type Telnet struct {
 	conn        net.Conn
 	ignoreList  map[string]bool
 
-	// buffer is used for redrawing the terminal when new messages come
-	// in or the window is resized
-	buffer     [][]byte
+	// every channel the session is a member of in the order they were
+	// joined (for Alt-number switching), Chan is the one being viewed and
+	// composed in
+	channels []string
+	// the channel the session started in, where it goes back to if kicked
+	// from everything else
+	home string
+
+	// buffers are used for redrawing the terminal when new messages come
+	// in or the window is resized, one per channel. entries are rendered
+	// at draw time so they can be wrapped to whatever the current width is
+	buffers    map[string][]bufferEntry
 	bufferSize int
 	bufferMtx  sync.Mutex
 
+	// how many rows back from the newest message the chat window is
+	// scrolled, 0 means we're following live messages
+	scroll int
+
 	// stored width and height for redrawing terminal
 	width  int
 	height int
 
 	//used to identify clients we can assert sizes for
 	richClient bool
+
+	// terminal type reported by the client and whether it can handle ansi
+	// escape sequences, clients that can't just get plain lines
+	termType string
+	ansi     bool
+
+	// environment variables the client was willing to share
+	environ map[string]string
+
+	// how colors are drawn, see color.go
+	depth ColorDepth
+	theme string
+
+	// how timestamps are shown, see clock.go
+	location    *time.Location
+	clockFormat string
+
+	// server the session is connected to, used for looking up who else is
+	// around
+	host Host
+
+	// optional panes for rich clients, see layout.go
+	showSidebar bool
+	showStatus  bool
+
+	// count of messages received for channels other than the current one
+	unread    map[string]int
+	connected time.Time
+
+	// presence, see presence.go
+	away       string
+	lastActive time.Time
+
+	// words besides the username that count as mentions and the mentions
+	// from channels not being viewed, see mentions.go
+	highlights  []string
+	mentionList []Message
+
+	// last message sent that made it back from the host, for /edit and
+	// /delete
+	lastSent Message
+
+	// lines collected in /paste mode, see paste.go. only touched by the
+	// read loop
+	pasting   bool
+	paste     []string
+	pasteSize int
 }
 
 // helper method to create new telnet session
 func NewTelnet(conn net.Conn, bufferSize int, usernameColor, channel string) *Telnet {
 	return &Telnet{conn: conn, richClient: false, bufferSize: bufferSize,
-		color: usernameColor, Chan: channel, ignoreList: make(map[string]bool)}
+		color: usernameColor, Chan: channel, ignoreList: make(map[string]bool),
+		channels: []string{channel}, home: channel,
+		buffers: make(map[string][]bufferEntry),
+		unread:  make(map[string]int), connected: time.Now(),
+		environ: make(map[string]string), depth: ANSI_16, theme: "default",
+		location: time.Local, clockFormat: CLOCK_24H, lastActive: time.Now()}
 }
 
+// a single message or event held in the chat buffer
+type bufferEntry struct {
+	msg   Message
+	event bool
+	// mentions the session's user
+	highlight bool
+}
+
+// the channel being viewed, switchTo can change it from other goroutines
 func (s *Telnet) Channel() string {
+	s.bufferMtx.Lock()
+	defer s.bufferMtx.Unlock()
 	return s.Chan
 }
 
+func (s *Telnet) Channels() []string {
+	s.bufferMtx.Lock()
+	defer s.bufferMtx.Unlock()
+	return append([]string(nil), s.channels...)
+}
+
+// a copy of the ignore list, the host reads it from other goroutines
 func (s *Telnet) IgnoreList() map[string]bool {
-	return s.ignoreList
+	s.bufferMtx.Lock()
+	defer s.bufferMtx.Unlock()
+	ignores := make(map[string]bool, len(s.ignoreList))
+	for mask, ignored := range s.ignoreList {
+		ignores[mask] = ignored
+	}
+	return ignores
+}
+
+// host part of the connection's remote address
+func (s *Telnet) RemoteAddr() string {
+	addr := s.conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
 }
 
 func (s *Telnet) Close() error {
 	return s.conn.Close()
 }
 
+// turns "/me waves" into an action message
+func (s *Telnet) parseAction(input []byte) (action Message, ok bool) {
+	text := string(input)
+	if !strings.HasPrefix(text, "/me ") {
+		return action, false
+	}
+	action = s.newMessage([]byte(strings.TrimPrefix(text, "/me ")))
+	action.Kind = KIND_ACTION
+	return action, true
+}
+
 // helper method to add appropriate metadata to message from telnet session
 func (s *Telnet) newMessage(bodyBytes []byte) Message {
-	//filter out inappropriate bytes
-	filteredBodyBytes := bodyBytes[:0]
-	for _, b := range bodyBytes {
+	body := string(filterInput(bodyBytes))
+
+	return NewMessage(string(body), s.Channel(), s)
+}
+
+// filter out inappropriate bytes from user input, in place
+func filterInput(input []byte) []byte {
+	filtered := input[:0]
+	for _, b := range input {
 		octet := int(b)
 		//other than CR and LF ensure chars are a restricted set of visual ascii
 		if octet == 10 || octet == 13 || octet >= 32 && octet <= 126 {
-			filteredBodyBytes = append(filteredBodyBytes, b)
+			filtered = append(filtered, b)
 		}
 	}
-
-	body := string(filteredBodyBytes)
-
-	return NewMessage(string(body), s.Channel(), s)
+	return filtered
 }
 
-func (s *Telnet) GetMessages(usernameAvailable func(string) bool) (msg,
-	event chan Message, done chan error) {
+func (s *Telnet) GetMessages(host Host) (msg, event chan Message,
+	done chan error) {
+	s.host = host
 	msg = make(chan Message)
 	event = make(chan Message)
 	done = make(chan error, 1)
 
-	// we setup naws and username before giving the server a chance
-	// to send us messages or receive them
-	err := s.naws()
+	// we setup terminal options and username before giving the server a
+	// chance to send us messages or receive them
+	err := s.negotiate()
 	if err != nil {
 		// preload done so the server removes the session
 		done <- err
 		return msg, event, done
 	}
 
-	err = s.getUsername(usernameAvailable)
+	err = s.getUsername(host)
 	if err != nil {
 		// preload done so the server removes the session
 		done <- err
@@ -148,14 +267,21 @@ func (s *Telnet) GetMessages(usernameAvailable func(string) bool) (msg,
 	}
 
 	go func() {
-		// do a fresh redraw on session setup
-		err = s.redrawAll()
+		// do a fresh redraw on session setup, line mode clients get a
+		// greeting instead so they know they're connected
+		if s.richClient {
+			err = s.redrawAll()
+		} else {
+			err = s.SendEvent(s.newMessage([]byte("connected as " + s.Name +
+				" in #" + s.Channel() + ", type /help for commands")))
+		}
 		if err != nil {
 			done <- err
 			return
 		}
 
 		b := make([]byte, EXPECTED_MSG_SIZE)
+		var pending []byte
 		for {
 			n, err := s.conn.Read(b)
 			// bail if we get an error when reading
@@ -163,23 +289,97 @@ func (s *Telnet) GetMessages(usernameAvailable func(string) bool) (msg,
 				done <- err
 				return
 			}
-			if n > 0 {
+			// attend to telnet commands (like NAWS updates) and carry on
+			// with whatever the user actually typed
+			input, err := s.handleTelnetCommands(b[:n])
+			if err != nil {
+				done <- err
+				return
+			}
+
+			// a read that fills the buffer without finishing a line is
+			// part of something bigger (usually a paste), hold on to it
+			// until the rest arrives
+			pending = append(pending, input...)
+			if n == len(b) && !endsLine(pending) &&
+				len(pending) < MAX_PASTE_SIZE {
+				continue
+			}
+			input, pending = pending, nil
+
+			if len(input) > 0 {
+				s.touch()
 
-				// if we have a rich client look out for NAWS updates
+				// Alt-number switches channels
+				switched, err := s.handleSwitchKeys(input)
+				if err != nil {
+					done <- err
+					return
+				}
+
+				if switched {
+					continue
+				}
+
+				// if we have a rich client look out for scrolling keys
 				if s.richClient {
-					nawsUpdate, err := s.handleNawsUpdates(b[:n])
+					scrolled, err := s.handleScrollKeys(input)
 					if err != nil {
 						done <- err
 						return
 					}
 
-					if nawsUpdate {
+					if scrolled {
 						continue
 					}
 				}
 
+				// any other input snaps the chat window back to live
+				s.scrollToLive()
+
+				// /paste mode and bulk pastes are collected into
+				// multi-line messages
+				pasted, isPaste, err := s.handlePaste(input)
+				if err != nil {
+					done <- err
+					return
+				}
+				if isPaste {
+					if pasted.Body != "" {
+						msg <- pasted
+						err = s.redrawAll()
+						if err != nil {
+							done <- err
+							return
+						}
+					}
+					continue
+				}
+
+				// /me and /reply are messages of their own rather than
+				// commands
+				if m, ok := s.parseMessageCommand(input); ok {
+					msg <- m
+					err = s.redrawAll()
+					if err != nil {
+						done <- err
+						return
+					}
+					continue
+				}
+
+				// s/old/new/ fixes the last message sent
+				substituted, err := s.handleSubstitution(input)
+				if err != nil {
+					done <- err
+					return
+				}
+				if substituted {
+					continue
+				}
+
 				// attend to any commands before creating a new message
-				wasCommand, err := s.parseCommand(b[:n])
+				wasCommand, err := s.parseCommand(input)
 				if err != nil {
 					done <- err
 					return
@@ -189,7 +389,7 @@ func (s *Telnet) GetMessages(usernameAvailable func(string) bool) (msg,
 					continue
 				}
 
-				m := s.newMessage(b[:n])
+				m := s.newMessage(input)
 				msg <- m
 
 				// redraw after a new message processed so the
@@ -213,35 +413,264 @@ func (s *Telnet) raw(msg []byte) (err error) {
 }
 
 // helper to add messages to buffer
-func (s *Telnet) appendToBuffer(line string) {
+func (s *Telnet) appendToBuffer(entry bufferEntry) {
 	s.bufferMtx.Lock()
 	defer s.bufferMtx.Unlock()
 
 	// if existing buffer is smaller than bufferSize change end to avoid
 	// nonexistant index accessing
+	channel := entry.msg.Channel
+	buffer := s.buffers[channel]
 	end := s.bufferSize
-	if len(s.buffer) < end {
-		end = len(s.buffer)
+	if len(buffer) < end {
+		end = len(buffer)
+	}
+	s.buffers[channel] = append([]bufferEntry{entry}, buffer[:end]...)
+
+	// keep the view anchored on the same lines while scrolled back
+	if s.scroll > 0 && channel == s.Chan {
+		s.scroll += len(s.renderRows(entry))
+		s.clampScroll()
+	}
+}
+
+// strips line endings and control characters from a message body so it
+// can't move the cursor around when drawn
+func displayBody(body string) string {
+	body = strings.TrimRight(body, "\r\n")
+	return safeText(strings.NewReplacer("\r", "", "\n", " ").Replace(body))
+}
+
+// renders a buffer entry into a single line along with how far continuation
+// rows should be indented so they hang under the message body. expects
+// bufferMtx to be held
+func (s *Telnet) renderEntry(entry bufferEntry) (line string, indent int) {
+	msg := entry.msg
+	if entry.event || msg.Kind == KIND_SYSTEM {
+		return s.eventColor() + displayBody(msg.Body) + s.messageColor(), 0
+	}
+
+	// each kind marks who it's from differently, notices are drawn dim so
+	// they don't read as someone talking
+	from := msg.From.Username()
+	separator := ":"
+	bodyColor := s.messageColor()
+	switch msg.Kind {
+	case KIND_ACTION:
+		from = "* " + from
+		separator = ""
+	case KIND_NOTICE:
+		from = "-" + from + "-"
+		separator = ""
+		bodyColor = s.eventColor()
+	case KIND_DM:
+		// direct messages say who they were between
+		from += " -> " + msg.To
+	}
+
+	lines := msg.Lines()
+	for i := range lines {
+		lines[i] = s.renderMarkup(lines[i], bodyColor)
+	}
+	body := lines[0]
+	if len(lines) > 1 {
+		// multi-line messages are drawn as a block with a gutter down
+		// the side, rows after the first are lined up by renderRows
+		gutter := s.eventColor() + "| " + bodyColor
+		body = gutter + strings.Join(lines, "\n"+gutter)
+	}
+	switch {
+	case msg.Deleted:
+		body, bodyColor = "(message deleted)", s.eventColor()
+	case msg.Edited:
+		body += s.eventColor() + " (edited)"
+	}
+	if msg.ReplyTo != 0 && !msg.Deleted {
+		body = s.eventColor() + s.quoteParent(msg) + bodyColor + " " + body
+	}
+	if len(msg.Reactions) > 0 && !msg.Deleted {
+		body += " " + s.eventColor() + describeReactions(msg.Reactions)
+	}
+
+	if entry.highlight && s.ansi {
+		// reverse video works even without color
+		from = REVERSE_VIDEO + from + separator + RESET
+	} else {
+		from += separator
+	}
+	// ids are what other commands refer to messages by
+	stamp := s.formatTime(msg.T)
+	if msg.ID != 0 {
+		stamp += "|" + strconv.FormatUint(msg.ID, 10)
+	}
+	prefix := s.eventColor() + "[" + stamp + "] " +
+		s.usernameColor(msg.From.UsernameColor()) + from + " "
+	indent = visibleWidth(prefix)
+	line = prefix + bodyColor + body + s.messageColor()
+	return strings.Replace(line, "\n", "\n"+strings.Repeat(" ", indent), -1),
+		indent
+}
+
+// renders and wraps an entry into the rows it takes up on screen
+func (s *Telnet) renderRows(entry bufferEntry) (rows []string) {
+	line, indent := s.renderEntry(entry)
+	for _, row := range strings.Split(line, "\n") {
+		rows = append(rows, wrapLine(row, s.chatWidth(), indent)...)
+	}
+	return rows
+}
+
+// wraps the buffer into screen rows, newest row first. stops once limit rows
+// have been gathered, a negative limit wraps everything. expects bufferMtx to
+// be held
+func (s *Telnet) bufferRows(limit int) []string {
+	var rows []string
+	buffer := s.buffers[s.Chan]
+	for idx, entry := range buffer {
+		if limit >= 0 && len(rows) >= limit {
+			break
+		}
+		entryRows := s.renderRows(entry)
+		for i := len(entryRows) - 1; i >= 0; i-- {
+			rows = append(rows, entryRows[i])
+		}
+
+		// mark where the day changes from the entry before this one
+		if idx+1 < len(buffer) &&
+			s.day(buffer[idx+1].msg.T) != s.day(entry.msg.T) {
+			rows = append(rows, s.dateSeparator(entry.msg.T))
+		}
 	}
-	s.buffer = append([][]byte{[]byte(line)}, s.buffer[:end]...)
+	return rows
+}
+
+// number of rows available for chat lines (everything above the compose
+// window and status bar)
+func (s *Telnet) chatRows() int {
+	if s.showStatus {
+		return s.height - 2
+	}
+	return s.height - 1
+}
+
+// keeps the scroll offset within the lines we actually have buffered, expects
+// bufferMtx to be held
+func (s *Telnet) clampScroll() {
+	// while scrolled the last row is used by the indicator so one less
+	// line of history fits on screen
+	maxScroll := len(s.bufferRows(-1)) - (s.chatRows() - 1)
+	if s.scroll > maxScroll {
+		s.scroll = maxScroll
+	}
+	if s.scroll < 0 {
+		s.scroll = 0
+	}
+}
+
+// moves the chat window by delta lines, positive values go back in time
+func (s *Telnet) scrollBy(delta int) {
+	s.bufferMtx.Lock()
+	defer s.bufferMtx.Unlock()
+	s.scroll += delta
+	s.clampScroll()
+}
+
+// returns the chat window to following new messages
+func (s *Telnet) scrollToLive() {
+	s.bufferMtx.Lock()
+	s.scroll = 0
+	s.bufferMtx.Unlock()
+}
+
+// how far a single PageUp/PageDown moves the chat window
+func (s *Telnet) pageSize() int {
+	if s.chatRows() > 2 {
+		return s.chatRows() - 2
+	}
+	return 1
+}
+
+// scrolls the chat window if the input consists solely of page up/down
+// keypresses
+func (s *Telnet) handleScrollKeys(b []byte) (isScroll bool, err error) {
+	input := strings.TrimSpace(string(b))
+	ups := strings.Count(input, PAGE_UP)
+	downs := strings.Count(input, PAGE_DOWN)
+
+	// anything else on the line means this wasn't meant as scrolling
+	if ups+downs == 0 || len(input) != (ups+downs)*len(PAGE_UP) {
+		return false, nil
+	}
+
+	s.scrollBy((ups - downs) * s.pageSize())
+	return true, s.redrawAll()
+}
+
+// writes an entry as a single line for clients we can't draw a full
+// interface for
+func (s *Telnet) printLine(entry bufferEntry) (err error) {
+	// entries are added to the buffer before being printed so the one
+	// before is what the user saw last
+	s.bufferMtx.Lock()
+	line, _ := s.renderEntry(entry)
+	line = strings.Replace(line, "\n", "\r\n", -1)
+	buffer := s.buffers[entry.msg.Channel]
+	if len(buffer) > 1 && s.day(buffer[1].msg.T) != s.day(entry.msg.T) {
+		line = s.dateSeparator(entry.msg.T) + "\r\n" + line
+	}
+	multipleChannels := len(s.channels) > 1
+	s.bufferMtx.Unlock()
+
+	// there's only one line of output so say where things are from when
+	// following more than one channel
+	if multipleChannels {
+		line = s.eventColor() + "[#" + entry.msg.Channel + "] " + line
+	}
+
+	if s.ansi {
+		line += RESET
+	} else {
+		line = stripEscapes(line)
+	}
+	return s.raw([]byte(line + "\r\n"))
 }
 
 func (s *Telnet) SendMessage(msg Message) (err error) {
-	body := msg.Body
-	from := msg.From
+	// keep track of what's being missed in other channels for the
+	// status bar
+	if msg.Channel != s.Channel() {
+		s.bufferMtx.Lock()
+		s.unread[msg.Channel]++
+		s.bufferMtx.Unlock()
+	}
 
-	body = EVENT_COLOR + "[" + msg.T.Format("15:04:05") + "] " +
-		getTelnetColor(from.UsernameColor()) + from.Username() + ": " +
-		MESSAGE_COLOR + body
+	// our own messages come back with an id we can edit them by
+	if msg.From == Session(s) && msg.ID != 0 {
+		s.bufferMtx.Lock()
+		s.lastSent = msg
+		s.bufferMtx.Unlock()
+	}
 
-	s.appendToBuffer(body)
+	entry := bufferEntry{msg: msg, highlight: s.mentions(msg)}
+	s.appendToBuffer(entry)
+	if entry.highlight {
+		err = s.notifyMention(msg)
+		if err != nil {
+			return err
+		}
+	}
+	if !s.richClient {
+		return s.printLine(entry)
+	}
 	return s.redrawChat()
 }
 
 func (s *Telnet) SendEvent(event Message) (err error) {
-	msg := event.Body
-	msg = EVENT_COLOR + msg + MESSAGE_COLOR
-	s.appendToBuffer(msg)
+	entry := bufferEntry{msg: event, event: true}
+	s.appendToBuffer(entry)
+	if !s.richClient {
+		return s.printLine(entry)
+	}
 	return s.redrawChat()
 }
 
@@ -253,7 +682,7 @@ func (s *Telnet) UsernameColor() string {
 	return s.color
 }
 
-func (s *Telnet) getUsername(available func(string) bool) (err error) {
+func (s *Telnet) getUsername(host Host) (err error) {
 	// clear screen for formatting
 	err = s.clearScreen()
 	if err != nil {
@@ -270,93 +699,51 @@ func (s *Telnet) getUsername(available func(string) bool) (err error) {
 			return err
 		}
 
-		input := b[:n]
-
-		if n > 0 && b[0] != IAC && len(strings.TrimSpace(string(input))) != 0 {
-			username := strings.TrimSpace(string(input))
-			if available(username) {
-				s.Name = username
-				err = s.clearScreen()
-				return err
-			} else {
-				s.raw([]byte("Username already taken\r\nusername: "))
-			}
-		}
-	}
-}
-
-// Determine window size of session terminal
-// [N]egotiate [A]bout [W]indow [S]ize
-func (s *Telnet) naws() error {
-	// inform client we want to do NAWS
-	s.raw([]byte{IAC, DO, NAWS})
-	b := make([]byte, EXPECTED_MSG_SIZE)
-	for {
-		n, err := s.conn.Read(b)
+		// late answers to option negotiation aren't part of the username
+		input, err := s.handleTelnetCommands(b[:n])
 		if err != nil {
 			return err
 		}
 
-		if n == 0 {
-			continue
-		}
-
-		// not a command, continue until we get a response
-		if b[0] != IAC {
-			continue
-		}
+		if len(strings.TrimSpace(string(input))) != 0 {
+			username := strings.TrimSpace(string(input))
+			if !host.UsernameAvailable(username) {
+				s.raw([]byte("Username already taken\r\nusername: "))
+				continue
+			}
 
-		// if we can do naws, set rich client status to true and
-		// handle the naws update
-		if b[1] == WILL && b[2] == NAWS {
-			s.richClient = true
-			if n > 3 {
-				s.handleNawsUpdates(b[3:])
+			// registered usernames are only for whoever knows the password
+			if host.Registered(username) {
+				ok, err := s.login(host, username)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					s.raw([]byte("Wrong password\r\nusername: "))
+					continue
+				}
 			}
-			break
-			// if client refused to do naws set rich client to false and break
-		} else if b[1] == WONT && b[2] == NAWS {
-			s.richClient = false
-			break
+
+			s.Name = username
+			err = s.clearScreen()
+			return err
 		}
 	}
-	return nil
 }
 
 // sends clear screen escape sequence to terminal
 func (s *Telnet) clearScreen() (err error) {
+	// terminals that can't handle escapes would just print garbage
+	if !s.ansi {
+		return nil
+	}
 	_, err = s.conn.Write([]byte("\033[2J\033[0;0H"))
 	return err
 }
 
-// resize virtual terminal info when we get naws info
-func (s *Telnet) handleNawsUpdates(b []byte) (isNaws bool, err error) {
-	if b[0] == IAC && b[1] == SB && b[2] == NAWS {
-		width := 0
-		if int(b[3]) == 1 {
-			width += 256
-		}
-		width += int(b[4])
-
-		height := 0
-		if int(b[5]) == 1 {
-			height += 256
-		}
-		height += int(b[6])
-
-		s.width = width
-		s.height = height
-
-		// redraw based on new size info
-		s.redrawAll()
-		return true, err
-	}
-	return false, nil
-}
-
 // inform user to the status of their ignoring a certain user
 func (s *Telnet) displayIgnoreStatus(user string) (err error) {
-	if s.ignoreList[user] {
+	if s.IgnoreList()[user] {
 		return s.SendEvent(s.newMessage([]byte(user +
 			" is now being ignored.")))
 	} else {
@@ -373,13 +760,13 @@ func (s *Telnet) parseCommand(b []byte) (isCommand bool, err error) {
 		return false, nil
 	}
 
-	// get all arguments
-	cmd := strings.Split(string(b), " ")
+	// get all arguments, arguments end up in topics and the like so they
+	// get the same filtering as messages
+	cmd := strings.Split(string(filterInput(b)), " ")
 
 	switch strings.TrimSpace(cmd[0]) {
 	case "/help":
-		// show all commands when /help typed
-		err = s.SendEvent(s.newMessage([]byte(commandHelp)))
+		err = s.helpCommand(commandText(cmd))
 		if err != nil {
 			return true, err
 		}
@@ -395,45 +782,160 @@ func (s *Telnet) parseCommand(b []byte) (isCommand bool, err error) {
 				return true, err
 			}
 		} else {
-			// update session's channel for messages
-			s.Chan = string(strings.TrimSpace(cmd[1]))
-			err = s.SendEvent(s.newMessage([]byte("now in channel #" +
-				s.Channel())))
+			password := ""
+			if len(cmd) > 2 {
+				password = strings.TrimSpace(cmd[2])
+			}
+			err = s.joinCommand(strings.TrimSpace(cmd[1]), password)
 			if err != nil {
 				return true, err
 			}
 		}
+	case "/leave":
+		leave := ""
+		if len(cmd) > 1 {
+			leave = strings.TrimSpace(cmd[1])
+		}
+		err = s.leaveCommand(leave)
+		if err != nil {
+			return true, err
+		}
+	case "/switch":
+		target := ""
+		if len(cmd) > 1 {
+			target = cmd[1]
+		}
+		err = s.switchCommand(target)
+		if err != nil {
+			return true, err
+		}
 	case "/ignore":
-		if len(cmd) < 2 || len(cmd[1]) == 0 {
-			// if inappropriate args sent for ignore
-			// inform user of proper usage
-			err = s.SendEvent(s.newMessage([]byte(ignoreHelp)))
-			if err != nil {
-				return true, err
-			}
-		} else {
-			user := string(strings.TrimSpace(cmd[1]))
-			if _, ok := s.ignoreList[user]; ok {
-				// allow user to stop ignoring a user by doing
-				// /ignore [user] again
-				s.ignoreList[user] = !s.ignoreList[user]
-			} else {
-				s.ignoreList[user] = true
-			}
-
-			err = s.displayIgnoreStatus(user)
-			if err != nil {
-				return true, err
-			}
+		mask := ""
+		if len(cmd) > 1 {
+			mask = strings.TrimSpace(cmd[1])
+		}
+		err = s.ignoreCommand(mask)
+		if err != nil {
+			return true, err
+		}
+	case "/ignores":
+		err = s.ignoresCommand()
+		if err != nil {
+			return true, err
+		}
+	case "/away":
+		err = s.awayCommand(commandText(cmd))
+		if err != nil {
+			return true, err
+		}
+	case "/back":
+		err = s.backCommand()
+		if err != nil {
+			return true, err
+		}
+	case "/seen":
+		err = s.seenCommand(commandText(cmd))
+		if err != nil {
+			return true, err
+		}
+	case "/reply":
+		// well formed replies never make it here
+		err = s.SendEvent(s.newMessage([]byte(replyHelp)))
+		if err != nil {
+			return true, err
+		}
+	case "/thread":
+		err = s.threadCommand(commandText(cmd))
+		if err != nil {
+			return true, err
+		}
+	case "/edit":
+		err = s.editCommand(commandText(cmd))
+		if err != nil {
+			return true, err
+		}
+	case "/delete":
+		err = s.deleteCommand()
+		if err != nil {
+			return true, err
+		}
+	case "/links":
+		err = s.linksCommand()
+		if err != nil {
+			return true, err
+		}
+	case "/highlight":
+		err = s.highlightCommand(commandText(cmd))
+		if err != nil {
+			return true, err
+		}
+	case "/mentions":
+		err = s.mentionsCommand()
+		if err != nil {
+			return true, err
+		}
+	case "/scroll":
+		err = s.scrollCommand(cmd[1:])
+		if err != nil {
+			return true, err
+		}
+	case "/layout":
+		err = s.layoutCommand(cmd[1:])
+		if err != nil {
+			return true, err
+		}
+	case "/theme":
+		err = s.themeCommand(cmd[1:])
+		if err != nil {
+			return true, err
+		}
+	case "/tz":
+		err = s.tzCommand(cmd[1:])
+		if err != nil {
+			return true, err
+		}
+	case "/timefmt":
+		err = s.timefmtCommand(cmd[1:])
+		if err != nil {
+			return true, err
 		}
 	default:
-		return false, nil
+		// anything else might be one of the host's commands
+		return s.hostCommand(cmd)
 	}
 
 	err = s.redrawAll()
 	return true, err
 }
 
+// handles /scroll [up|down|end] [lines]
+func (s *Telnet) scrollCommand(args []string) (err error) {
+	if len(args) == 0 {
+		return s.SendEvent(s.newMessage([]byte(scrollHelp)))
+	}
+
+	// default to a page at a time unless told otherwise
+	lines := s.pageSize()
+	if len(args) > 1 {
+		lines, err = strconv.Atoi(strings.TrimSpace(args[1]))
+		if err != nil || lines < 1 {
+			return s.SendEvent(s.newMessage([]byte(scrollHelp)))
+		}
+	}
+
+	switch strings.TrimSpace(args[0]) {
+	case "up":
+		s.scrollBy(lines)
+	case "down":
+		s.scrollBy(-lines)
+	case "end":
+		s.scrollToLive()
+	default:
+		return s.SendEvent(s.newMessage([]byte(scrollHelp)))
+	}
+	return nil
+}
+
 // generate payload of bytes for redrawing chat window
 func (s *Telnet) redrawChatBytes() []byte {
 	// batch all writes into a single payload so we only write to
@@ -442,26 +944,46 @@ func (s *Telnet) redrawChatBytes() []byte {
 	// sequence to move cursor to top left of terminal
 	payload := []byte("\033[0;0H")
 
+	// ask the server who's around before taking the buffer lock
+	sidebar := s.sidebarCells()
+	topic := s.channelTopic()
+
 	s.bufferMtx.Lock()
 	defer s.bufferMtx.Unlock()
 
-	// last line should stop before compose window
-	lastLine := s.height - 1
-	existingBufferLength := len(s.buffer)
+	// last line should stop before compose window, fill from the bottom up
+	// starting at however far back we're scrolled
+	lastLine := s.chatRows()
+	idx := s.scroll
+	rows := s.bufferRows(s.scroll + lastLine)
 
-	for i := 0; i <= lastLine; i++ {
+	for row := lastLine; row >= 1; row-- {
 		// for each line, we jump the cursor to that position
 		// and clear the line
 		payload = append(payload,
-			[]byte("\033["+strconv.Itoa(i)+";0H\033[K")...)
-		idx := lastLine - i
+			[]byte("\033["+strconv.Itoa(row)+";0H\033[K")...)
+
+		if row == lastLine && s.scroll > 0 {
+			// let the user know there are newer messages they aren't
+			// seeing
+			payload = append(payload,
+				[]byte(s.eventColor()+scrollIndicator+s.messageColor())...)
+		} else {
+			// if we have a message for that line, add it to the buffer
+			// otherwise it remains an empty line
+			if idx < len(rows) {
+				payload = append(payload, rows[idx]...)
+			}
+			idx++
+		}
 
-		// if we have a message for that line, add it to the buffer
-		// otherwise it remains an empty line
-		if idx < existingBufferLength {
-			payload = append(payload, s.buffer[idx]...)
+		if sidebar != nil {
+			payload = append(payload, s.sidebarBytes(row, sidebar)...)
 		}
+	}
 
+	if s.showStatus {
+		payload = append(payload, s.statusBarBytes(topic)...)
 	}
 	return payload
 }
@@ -487,6 +1009,6 @@ func (s *Telnet) redrawAll() (err error) {
 	}
 	payload := s.redrawChatBytes()
 	payload = append(payload, []byte("\033["+strconv.Itoa(s.height)+";0H\033[K"+
-		EVENT_COLOR+"[#"+s.Channel()+"] "+MESSAGE_COLOR)...)
+		s.eventColor()+"[#"+s.Channel()+"] "+s.messageColor())...)
 	return s.raw(payload)
 }
@@ -1,9 +1,39 @@
 package session
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// conn that records everything written to it and reads back whatever is
+// queued up in reads
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+	reads   []string
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	if len(c.reads) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, c.reads[0])
+	c.reads = c.reads[1:]
+	return n, nil
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	return c.written.Write(b)
+}
 
 func createTelnet() *Telnet {
-	return NewTelnet(nil, 5, "fuschia", "testchannel")
+	return NewTelnet(&recordingConn{}, 5, "fuschia", "testchannel")
 }
 
 func TestTelnetMessageCreation(t *testing.T) {
@@ -38,3 +68,824 @@ func TestTelnetRemovesUserEscapeSequences(t *testing.T) {
 			msg.Body)
 	}
 }
+
+func TestTelnetScrollback(t *testing.T) {
+	tel := createTelnet()
+	tel.height = 4
+	for _, line := range []string{"one", "two", "three", "four", "five"} {
+		tel.SendEvent(tel.newMessage([]byte(line)))
+	}
+
+	live := string(tel.redrawChatBytes())
+	if !strings.Contains(live, "five") || strings.Contains(live, "two") {
+		t.Errorf("live view should show newest lines %q", live)
+	}
+
+	tel.scrollBy(2)
+	scrolled := string(tel.redrawChatBytes())
+	if !strings.Contains(scrolled, scrollIndicator) {
+		t.Errorf("scrolled view missing indicator %q", scrolled)
+	}
+	if !strings.Contains(scrolled, "two") || strings.Contains(scrolled, "five") {
+		t.Errorf("scrolled view showing wrong lines %q", scrolled)
+	}
+
+	// new lines shouldn't move the view while scrolled back
+	tel.SendEvent(tel.newMessage([]byte("six")))
+	if tel.scroll != 3 {
+		t.Errorf("scroll position not anchored %d", tel.scroll)
+	}
+
+	tel.scrollToLive()
+	if strings.Contains(string(tel.redrawChatBytes()), scrollIndicator) {
+		t.Errorf("indicator shown while live")
+	}
+}
+
+func TestTelnetScrollIsClamped(t *testing.T) {
+	tel := createTelnet()
+	tel.height = 4
+	tel.SendEvent(tel.newMessage([]byte("one")))
+	tel.scrollBy(100)
+	if tel.scroll != 0 {
+		t.Errorf("scrolled past available history %d", tel.scroll)
+	}
+}
+
+func TestTelnetWrapsToWidth(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "tester"
+	tel.width = 30
+	tel.height = 10
+	tel.SendMessage(tel.newMessage([]byte("a message that is too long for one row\r\n")))
+
+	rows := tel.bufferRows(-1)
+	if len(rows) < 2 {
+		t.Fatalf("message not wrapped %q", rows)
+	}
+	for _, row := range rows {
+		if visibleWidth(row) > tel.width {
+			t.Errorf("row wider than terminal %q", row)
+		}
+	}
+
+	// hanging indent lines up under the body, past "[15:04:05] tester: "
+	if !strings.HasPrefix(rows[0], strings.Repeat(" ", 19)) {
+		t.Errorf("continuation row not indented %q", rows[0])
+	}
+
+	// widening the terminal rewraps the same buffer
+	tel.width = 80
+	if rows := tel.bufferRows(-1); len(rows) != 1 {
+		t.Errorf("buffer not rewrapped on resize %q", rows)
+	}
+}
+
+type mockHost struct {
+	members []Session
+	history []Message
+	edits   []string
+	// arguments each host command was run with
+	commands [][]string
+	// names of the host's commands that have been run
+	ran []string
+}
+
+func (h *mockHost) UsernameAvailable(string) bool {
+	return true
+}
+
+func (h *mockHost) Registered(username string) bool {
+	return username == "registered"
+}
+
+func (h *mockHost) Login(username, password string) bool {
+	return password == "secret"
+}
+
+func (h *mockHost) Register(Session, string) error {
+	return nil
+}
+
+func (h *mockHost) SavedIgnores(string) []string {
+	return []string{"troll"}
+}
+
+func (h *mockHost) SaveIgnores(Session) error {
+	return nil
+}
+
+func (h *mockHost) DirectMessage(sesh Session, username, body string) error {
+	if username == "nobody" {
+		return errors.New("nobody isn't online")
+	}
+	return nil
+}
+
+func (h *mockHost) EditMessage(sesh Session, id uint64, body string) error {
+	h.edits = append(h.edits, body)
+	return nil
+}
+
+func (h *mockHost) Thread(Session, uint64) ([]Message, error) {
+	return h.history, nil
+}
+
+func (h *mockHost) DeleteMessage(Session, uint64) error {
+	return nil
+}
+
+func (h *mockHost) LastSeen(username string) (time.Time, bool) {
+	if username == "gone" {
+		return time.Now().Add(-2 * time.Hour), false
+	}
+	return time.Time{}, false
+}
+
+func (h *mockHost) ChannelMembers(string) []Session {
+	return h.members
+}
+
+func (h *mockHost) JoinChannel(sesh Session, channel,
+	password string) (ChannelInfo, error) {
+	if channel == "forbidden" {
+		return ChannelInfo{}, errors.New("not allowed")
+	}
+	if channel == "private" && password != "secret" {
+		return ChannelInfo{}, errors.New("wrong password")
+	}
+	return ChannelInfo{Name: strings.TrimPrefix(channel, "#"), Topic: "a topic",
+		History: h.history}, nil
+}
+
+func (h *mockHost) LeaveChannel(Session, string) {}
+
+func (h *mockHost) ChannelInfo(channel string) ChannelInfo {
+	return ChannelInfo{Name: channel, Topic: "a topic"}
+}
+
+func (h *mockHost) ListChannels(Session) []ChannelInfo {
+	return nil
+}
+
+func (h *mockHost) SetTopic(Session, string, string) error {
+	return nil
+}
+
+func (h *mockHost) SetChannelMode(Session, string, string, string) error {
+	return nil
+}
+
+func (h *mockHost) RunCommand(sesh Session, name string, args []string) (bool,
+	error) {
+	switch name {
+	case "/who":
+		h.ran = append(h.ran, name)
+		return true, nil
+	case "/deploy":
+	default:
+		return false, nil
+	}
+	h.ran = append(h.ran, name)
+	h.commands = append(h.commands, args)
+	if len(args) == 0 {
+		return true, errors.New("usage: /deploy [service]")
+	}
+	return true, nil
+}
+
+func (h *mockHost) Commands() []CommandInfo {
+	return append(TelnetCommands, CommandInfo{Name: "deploy",
+		Usage: "[service]", Help: "shows deploy status"})
+}
+
+func TestTelnetLayoutPanes(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "tester"
+	other := createTelnet()
+	other.Name = "someoneelse"
+	other.away = "lunch"
+	idle := createTelnet()
+	idle.Name = "sleepy"
+	idle.lastActive = time.Now().Add(-IDLE_AFTER)
+	tel.host = &mockHost{members: []Session{tel, other, idle}}
+	tel.width = 80
+	tel.height = 10
+
+	tel.layoutCommand(nil)
+	if !tel.showSidebar || !tel.showStatus {
+		t.Fatalf("layout not toggled on")
+	}
+
+	payload := string(tel.redrawChatBytes())
+	if !strings.Contains(payload, "someoneelse"+tel.eventColor()+" (away)") ||
+		!strings.Contains(payload, "sleepy"+tel.eventColor()+" (idle)") {
+		t.Errorf("member list not drawn with presence %q", payload)
+	}
+	if strings.Contains(payload, "tester"+tel.eventColor()+" (") {
+		t.Errorf("active member marked %q", payload)
+	}
+	if !strings.Contains(payload, REVERSE_VIDEO+" [1:#testchannel] | a topic") {
+		t.Errorf("status bar not drawn %q", payload)
+	}
+	if tel.chatWidth() != 80-SIDEBAR_WIDTH {
+		t.Errorf("chat not narrowed for sidebar %d", tel.chatWidth())
+	}
+
+	tel.layoutCommand([]string{"sidebar"})
+	if tel.showSidebar || tel.chatWidth() != 80 {
+		t.Errorf("sidebar not toggled off")
+	}
+}
+
+func TestTelnetCountsUnreadInOtherChannels(t *testing.T) {
+	tel := createTelnet()
+	tel.SendMessage(NewMessage("hi", "elsewhere", tel))
+	tel.SendMessage(NewMessage("hi", tel.Channel(), tel))
+
+	if tel.unread["elsewhere"] != 1 || tel.unread[tel.Channel()] != 0 {
+		t.Errorf("incorrect unread counts %v", tel.unread)
+	}
+}
+
+func TestParseTelnetCommands(t *testing.T) {
+	input := []byte{IAC, WILL, NAWS, IAC, SB, NAWS, 0, 80, 0, 24, IAC, SE, 'h', 'i'}
+	cmds, data := parseTelnetCommands(input)
+
+	if string(data) != "hi" {
+		t.Errorf("incorrect data left over %q", data)
+	}
+	if len(cmds) != 2 || cmds[0].verb != WILL || cmds[1].verb != SB {
+		t.Fatalf("incorrect commands parsed %v", cmds)
+	}
+	if !bytes.Equal(cmds[1].payload, []byte{0, 80, 0, 24}) {
+		t.Errorf("incorrect subnegotiation payload %v", cmds[1].payload)
+	}
+}
+
+func TestTelnetResize(t *testing.T) {
+	tel := createTelnet()
+	tel.resize([]byte{1, 44, 0, 24})
+	if tel.width != 300 || tel.height != 24 {
+		t.Errorf("incorrect size %dx%d", tel.width, tel.height)
+	}
+}
+
+func TestTelnetPlainLineMode(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "tester"
+	tel.SendMessage(tel.newMessage([]byte("hello\r\n")))
+	tel.SendEvent(tel.newMessage([]byte("someone joined")))
+
+	written := tel.conn.(*recordingConn).written.String()
+	if strings.Contains(written, "\033") {
+		t.Errorf("escape sequences sent to plain client %q", written)
+	}
+
+	lines := strings.Split(written, "\r\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], "tester: hello") ||
+		lines[1] != "someone joined" {
+		t.Errorf("incorrect plain lines %q", lines)
+	}
+}
+
+func TestTelnetPlainLineModeWithColor(t *testing.T) {
+	tel := createTelnet()
+	tel.ansi = true
+	tel.SendEvent(tel.newMessage([]byte("someone joined")))
+
+	written := tel.conn.(*recordingConn).written.String()
+	if !strings.HasPrefix(written, EVENT_COLOR) || !strings.HasSuffix(written, RESET+"\r\n") {
+		t.Errorf("color not kept for ansi capable client %q", written)
+	}
+}
+
+func TestDumbTerminalDetection(t *testing.T) {
+	if !isDumbTerminal("DUMB") || isDumbTerminal("XTERM-256COLOR") {
+		t.Errorf("incorrect dumb terminal detection")
+	}
+}
+
+func TestParseEnviron(t *testing.T) {
+	payload := []byte{ENV_IS, ENV_USERVAR}
+	payload = append(payload, "COLORTERM"...)
+	payload = append(payload, ENV_VALUE)
+	payload = append(payload, "truecolor"...)
+	payload = append(payload, ENV_VAR)
+	payload = append(payload, "USER"...)
+
+	environ := parseEnviron(payload)
+	if environ["COLORTERM"] != "truecolor" {
+		t.Errorf("incorrect COLORTERM %q", environ["COLORTERM"])
+	}
+	if value, ok := environ["USER"]; !ok || value != "" {
+		t.Errorf("undefined variable not recorded")
+	}
+}
+
+func TestDetectColorDepth(t *testing.T) {
+	cases := []struct {
+		termType  string
+		colorTerm string
+		depth     ColorDepth
+	}{
+		{"DUMB", "", MONOCHROME},
+		{"XTERM", "", ANSI_16},
+		{"", "", ANSI_16},
+		{"XTERM-256COLOR", "", ANSI_256},
+		{"XTERM-256COLOR", "truecolor", TRUECOLOR},
+	}
+
+	for _, c := range cases {
+		environ := map[string]string{"COLORTERM": c.colorTerm}
+		if depth := detectColorDepth(c.termType, environ); depth != c.depth {
+			t.Errorf("incorrect depth for %q/%q: %d", c.termType, c.colorTerm, depth)
+		}
+	}
+}
+
+func TestTelnetThemes(t *testing.T) {
+	tel := createTelnet()
+	tel.depth = ANSI_256
+	if tel.usernameColor("teal") != "\033[0;38;5;30m" {
+		t.Errorf("incorrect 256 color username %q", tel.usernameColor("teal"))
+	}
+
+	tel.themeCommand([]string{"mono"})
+	if tel.theme != "mono" || tel.usernameColor("teal") != "" ||
+		tel.messageColor() != "" {
+		t.Errorf("colors drawn with mono theme")
+	}
+	tel.themeCommand([]string{"none"})
+	tel.themeCommand([]string{"default"})
+	if tel.depth != MONOCHROME || tel.messageColor() != "" {
+		t.Errorf("colors drawn without any colors")
+	}
+	tel.themeCommand([]string{"256"})
+
+	// every theme can be picked
+	for name := range THEMES {
+		tel.themeCommand([]string{name})
+		if tel.theme != name {
+			t.Errorf("theme %s not selectable, got %s", name, tel.theme)
+		}
+	}
+
+	tel.themeCommand([]string{"truecolor"})
+	tel.themeCommand([]string{"light"})
+	if tel.theme != "light" || tel.depth != TRUECOLOR {
+		t.Errorf("theme not applied %s %d", tel.theme, tel.depth)
+	}
+}
+
+func TestTelnetTimezoneAndFormat(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "tester"
+	msg := tel.newMessage([]byte("hi"))
+	msg.T = time.Date(2020, 1, 1, 17, 30, 0, 0, time.UTC)
+
+	if !tel.setTimezone("America/New_York") {
+		t.Fatalf("known timezone rejected")
+	}
+	tel.timefmtCommand([]string{"12h"})
+
+	line, _ := tel.renderEntry(bufferEntry{msg: msg})
+	if !strings.Contains(line, "[12:30:00PM]") {
+		t.Errorf("timestamp not localized %q", line)
+	}
+
+	if tel.setTimezone("Not/AZone") {
+		t.Errorf("unknown timezone accepted")
+	}
+}
+
+func TestTelnetDateSeparators(t *testing.T) {
+	tel := createTelnet()
+	tel.setTimezone("UTC")
+	yesterday := tel.newMessage([]byte("old"))
+	yesterday.T = time.Date(2020, 1, 1, 23, 0, 0, 0, time.UTC)
+	today := tel.newMessage([]byte("new"))
+	today.T = time.Date(2020, 1, 2, 1, 0, 0, 0, time.UTC)
+	laterToday := tel.newMessage([]byte("newer"))
+	laterToday.T = time.Date(2020, 1, 2, 2, 0, 0, 0, time.UTC)
+
+	tel.SendEvent(yesterday)
+	tel.SendEvent(today)
+	tel.SendEvent(laterToday)
+
+	rows := tel.bufferRows(-1)
+	if len(rows) != 4 || !strings.Contains(rows[2], "Thursday, January 2 2020") {
+		t.Errorf("date separator missing %q", rows)
+	}
+}
+
+func TestTelnetJoinGoesThroughHost(t *testing.T) {
+	tel := createTelnet()
+	tel.host = &mockHost{}
+
+	tel.joinCommand("#ops", "")
+	if tel.Channel() != "ops" {
+		t.Errorf("channel not switched %s", tel.Channel())
+	}
+
+	tel.joinCommand("forbidden", "")
+	if tel.Channel() != "ops" {
+		t.Errorf("channel switched despite host refusing %s", tel.Channel())
+	}
+}
+
+func TestTelnetMultipleChannels(t *testing.T) {
+	tel := createTelnet()
+	tel.host = &mockHost{}
+	tel.joinCommand("ops", "")
+
+	if channels := tel.Channels(); len(channels) != 2 || channels[1] != "ops" {
+		t.Fatalf("incorrect channels %v", channels)
+	}
+
+	// messages for the channel not being viewed are counted and kept apart
+	tel.SendMessage(NewMessage("meanwhile", "testchannel", tel))
+	if tel.unread["testchannel"] != 1 {
+		t.Errorf("unread not counted %v", tel.unread)
+	}
+	for _, row := range tel.bufferRows(-1) {
+		if strings.Contains(row, "meanwhile") {
+			t.Errorf("other channel's message shown in current channel")
+		}
+	}
+
+	// Alt-1 goes back to the first channel
+	tel.handleSwitchKeys([]byte("\0331\r\n"))
+	if tel.Channel() != "testchannel" || tel.unread["testchannel"] != 0 {
+		t.Errorf("Alt-number didn't switch channels %s", tel.Channel())
+	}
+
+	tel.leaveCommand("ops")
+	if len(tel.Channels()) != 1 {
+		t.Errorf("channel not left %v", tel.Channels())
+	}
+
+	tel.leaveCommand("")
+	if len(tel.Channels()) != 1 {
+		t.Errorf("left only channel")
+	}
+}
+
+func TestTelnetKicked(t *testing.T) {
+	tel := createTelnet()
+	tel.host = &mockHost{}
+	tel.joinCommand("ops", "")
+
+	tel.Kicked("ops", "bob kicked you")
+	if tel.Channel() != "testchannel" {
+		t.Errorf("not switched away from kicked channel %s", tel.Channel())
+	}
+	if channels := tel.Channels(); len(channels) != 1 {
+		t.Errorf("kicked channel still listed %v", channels)
+	}
+
+	// kicked from everything puts us back home
+	tel.joinCommand("ops", "")
+	tel.switchTo("ops")
+	tel.leaveCommand("testchannel")
+	tel.Kicked("ops", "bob kicked you")
+	if channels := tel.Channels(); len(channels) != 1 ||
+		channels[0] != "testchannel" {
+		t.Errorf("not returned home %v", channels)
+	}
+}
+
+func TestTelnetJoinReplaysHistory(t *testing.T) {
+	tel := createTelnet()
+	other := createTelnet()
+	tel.host = &mockHost{history: []Message{
+		NewMessage("first", "private", other),
+		NewMessage("second", "private", other)}}
+
+	tel.joinCommand("private", "wrong")
+	if tel.isMember("private") {
+		t.Fatalf("joined private channel with wrong password")
+	}
+
+	tel.joinCommand("private", "secret")
+	buffer := tel.buffers["private"]
+	// newest first, with the join announcement on top
+	if len(buffer) != 3 || buffer[1].msg.Body != "second" ||
+		buffer[2].msg.Body != "first" {
+		t.Errorf("history not replayed in order %v", buffer)
+	}
+}
+
+func TestTelnetLogin(t *testing.T) {
+	conn := &recordingConn{reads: []string{"registered\r\n", "wrong\r\n",
+		"registered\r\n", "secret\r\n"}}
+	tel := NewTelnet(conn, 5, "fuschia", "testchannel")
+
+	err := tel.getUsername(&mockHost{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tel.Username() != "registered" {
+		t.Errorf("incorrect username %s", tel.Username())
+	}
+	if !strings.Contains(conn.written.String(), "Wrong password") {
+		t.Errorf("wrong password not reported")
+	}
+	if !tel.IgnoreList()["troll"] {
+		t.Errorf("saved ignores not loaded")
+	}
+
+	// the host gets a copy it can read while the list changes
+	ignores := tel.IgnoreList()
+	tel.host = &mockHost{}
+	tel.ignoreCommand("troll")
+	if !ignores["troll"] || tel.IgnoreList()["troll"] {
+		t.Errorf("ignore list shared with the host %v", ignores)
+	}
+}
+
+func TestTelnetDirectMessages(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "dan"
+	tel.host = &mockHost{}
+
+	// the host sends the sender their own copy
+	msg := tel.newMessage([]byte("hello there"))
+	msg.To, msg.Kind = "jon", KIND_DM
+	tel.SendMessage(msg)
+	buffer := tel.buffers[tel.Channel()]
+	if len(buffer) != 1 || buffer[0].msg.Body != "hello there" {
+		t.Fatalf("direct message not shown %v", buffer)
+	}
+
+	line, _ := tel.renderEntry(buffer[0])
+	if !strings.Contains(stripEscapes(line), "dan -> jon: hello there") {
+		t.Errorf("direct message rendered incorrectly %q", line)
+	}
+}
+
+func TestTelnetPresence(t *testing.T) {
+	tel := createTelnet()
+	tel.host = &mockHost{}
+
+	tel.awayCommand("lunch")
+	if tel.Away() != "lunch" || !strings.Contains(Presence(tel), "away: lunch") {
+		t.Errorf("away not marked %q", Presence(tel))
+	}
+	tel.backCommand()
+	if tel.Away() != "" {
+		t.Errorf("still away after /back")
+	}
+
+	tel.lastActive = time.Now().Add(-IDLE_AFTER - time.Minute)
+	if !strings.Contains(Presence(tel), "idle 11m") {
+		t.Errorf("idle not marked %q", Presence(tel))
+	}
+
+	tel.seenCommand("gone")
+	buffer := tel.buffers[tel.Channel()]
+	if !strings.Contains(buffer[0].msg.Body, "last seen 2h0m0s ago") {
+		t.Errorf("incorrect /seen output %q", buffer[0].msg.Body)
+	}
+}
+
+func TestContainsWord(t *testing.T) {
+	cases := map[string]bool{
+		"hey @dan, got a sec": true,
+		"DAN!":                true,
+		"dangerous":           false,
+		"jordan":              false,
+		"ask dan_b":           false,
+	}
+	for text, expected := range cases {
+		if containsWord(text, "dan") != expected {
+			t.Errorf("incorrect match for %q", text)
+		}
+	}
+}
+
+func TestTelnetMentions(t *testing.T) {
+	conn := &recordingConn{}
+	tel := NewTelnet(conn, 5, "fuschia", "testchannel")
+	tel.Name = "dan"
+	tel.ansi = true
+	other := createTelnet()
+	other.Name = "jon"
+
+	tel.SendMessage(NewMessage("hey @dan", "testchannel", other))
+	if !tel.buffers["testchannel"][0].highlight {
+		t.Errorf("mention not highlighted")
+	}
+	if !strings.Contains(conn.written.String(), BELL) {
+		t.Errorf("bell not rung for mention")
+	}
+
+	tel.highlightCommand("deploy")
+	tel.SendMessage(NewMessage("deploy is out", "ops", other))
+	tel.SendMessage(NewMessage("nothing to see", "ops", other))
+	if len(tel.mentionList) != 1 || tel.mentionList[0].Channel != "ops" {
+		t.Errorf("mention in other channel not kept %v", tel.mentionList)
+	}
+	if tel.buffers["ops"][0].highlight {
+		t.Errorf("unrelated message highlighted")
+	}
+}
+
+func TestTelnetMessageKinds(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "dan"
+
+	action, ok := tel.parseAction([]byte("/me waves\r\n"))
+	if !ok || action.Kind != KIND_ACTION {
+		t.Fatalf("/me not parsed as action %+v", action)
+	}
+	if _, ok := tel.parseAction([]byte("/mentions")); ok {
+		t.Errorf("/mentions parsed as action")
+	}
+
+	line, _ := tel.renderEntry(bufferEntry{msg: action})
+	if !strings.Contains(stripEscapes(line), "* dan waves") {
+		t.Errorf("action rendered incorrectly %q", stripEscapes(line))
+	}
+
+	notice := NewMessage("build passed", "testchannel", tel)
+	notice.Kind = KIND_NOTICE
+	line, _ = tel.renderEntry(bufferEntry{msg: notice})
+	if !strings.Contains(stripEscapes(line), "-dan- build passed") {
+		t.Errorf("notice rendered incorrectly %q", stripEscapes(line))
+	}
+
+	encoded, err := json.Marshal(Message{Body: "waves", Kind: KIND_ACTION})
+	if err != nil || !strings.Contains(string(encoded), `"kind":"action"`) {
+		t.Errorf("kind not encoded %s %v", encoded, err)
+	}
+}
+
+func TestTelnetEdits(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "dan"
+	host := &mockHost{}
+	tel.host = host
+
+	if substituted, _ := tel.handleSubstitution([]byte("s/helo/hello/\r\n")); substituted {
+		t.Errorf("substitution with nothing to edit")
+	}
+
+	msg := NewMessage("helo world", "testchannel", tel)
+	msg.ID = 7
+	tel.SendMessage(msg)
+	substituted, _ := tel.handleSubstitution([]byte("s/helo/hello/\r\n"))
+	if !substituted || len(host.edits) != 1 || host.edits[0] != "hello world" {
+		t.Errorf("substitution not sent as edit %v", host.edits)
+	}
+
+	msg.Body, msg.Edited = "hello world", true
+	tel.UpdateMessage(msg)
+	line, _ := tel.renderEntry(tel.buffers["testchannel"][0])
+	if !strings.Contains(stripEscapes(line), "|7] dan: hello world (edited)") {
+		t.Errorf("edit not applied to buffer %q", stripEscapes(line))
+	}
+
+	msg.Body, msg.Deleted = "", true
+	tel.UpdateMessage(msg)
+	line, _ = tel.renderEntry(tel.buffers["testchannel"][0])
+	if !strings.Contains(stripEscapes(line), "(message deleted)") {
+		t.Errorf("deletion not applied to buffer %q", stripEscapes(line))
+	}
+	if _, ok := tel.lastMessage(); ok {
+		t.Errorf("deleted message still editable")
+	}
+}
+
+func TestTelnetReplies(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "jon"
+	other := createTelnet()
+	other.Name = "dan"
+
+	parent := NewMessage("anyone up for lunch at noon today?", "testchannel", other)
+	parent.ID = 3
+	tel.SendMessage(parent)
+
+	reply, ok := tel.parseMessageCommand([]byte("/reply 3 sure\r\n"))
+	if !ok || reply.ReplyTo != 3 || reply.Body != "sure" {
+		t.Fatalf("reply not parsed %+v", reply)
+	}
+	if _, ok := tel.parseMessageCommand([]byte("/reply three sure")); ok {
+		t.Errorf("reply to bad id parsed")
+	}
+
+	reply.ID = 4
+	tel.SendMessage(reply)
+	line, _ := tel.renderEntry(tel.buffers["testchannel"][0])
+	if !strings.Contains(stripEscapes(line), "(re 3 dan: anyone up for lunch at noon...) sure") {
+		t.Errorf("reply rendered incorrectly %q", stripEscapes(line))
+	}
+}
+
+func TestTelnetReactions(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "dan"
+
+	msg := NewMessage("ship it?", "testchannel", tel)
+	msg.ID = 5
+	tel.SendMessage(msg)
+
+	msg.Reactions = map[string][]string{"eyes": {"jon"}, "+1": {"jon", "eve"}}
+	tel.UpdateMessage(msg)
+	line, _ := tel.renderEntry(tel.buffers["testchannel"][0])
+	if !strings.Contains(stripEscapes(line), "ship it? [+1 2] [eyes 1]") {
+		t.Errorf("reactions rendered incorrectly %q", stripEscapes(line))
+	}
+}
+
+func TestTelnetPaste(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "dan"
+
+	if _, isPaste, _ := tel.handlePaste([]byte("hello\r\n")); isPaste {
+		t.Errorf("single line treated as paste")
+	}
+
+	// several lines in one read were pasted
+	pasted, isPaste, _ := tel.handlePaste([]byte("func main() {\r\n\tgo()\r\n}\r\n"))
+	if !isPaste || pasted.Body != "func main() {\ngo()\n}" {
+		t.Errorf("bulk paste not collected %q", pasted.Body)
+	}
+
+	tel.handlePaste([]byte("/paste\r\n"))
+	if pasted, _, _ := tel.handlePaste([]byte("first\r\n")); pasted.Body != "" {
+		t.Errorf("paste sent before it was finished")
+	}
+	tel.handlePaste([]byte("second\r\n"))
+	pasted, isPaste, _ = tel.handlePaste([]byte(".\r\n"))
+	if !isPaste || pasted.Body != "first\nsecond" || tel.pasting {
+		t.Errorf("paste mode not finished %q", pasted.Body)
+	}
+
+	pasted.ID = 9
+	tel.SendMessage(pasted)
+	rows := tel.renderRows(tel.buffers["testchannel"][0])
+	if len(rows) != 2 || !strings.HasSuffix(stripEscapes(rows[0]), "dan: | first") ||
+		strings.TrimSpace(stripEscapes(rows[1])) != "| second" {
+		t.Errorf("paste not drawn as a block %q", rows)
+	}
+}
+
+func TestTelnetLinks(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "dan"
+
+	tel.linksCommand()
+	if !strings.Contains(tel.buffers["testchannel"][0].msg.Body, "no links") {
+		t.Errorf("empty channel listed links")
+	}
+
+	msg := NewMessage("read http://x.org", "testchannel", tel)
+	msg.Links = []Link{{URL: "http://x.org", Title: "X"}}
+	tel.SendMessage(msg)
+	tel.linksCommand()
+	if body := tel.buffers["testchannel"][0].msg.Body; !strings.HasSuffix(body,
+		"dan: http://x.org - X") {
+		t.Errorf("link not listed %q", body)
+	}
+}
+
+func TestTelnetCommands(t *testing.T) {
+	tel := createTelnet()
+	tel.Name = "dan"
+	host := &mockHost{}
+	tel.host = host
+
+	tel.helpCommand("")
+	help := tel.buffers["testchannel"][0].msg.Body
+	if !strings.Contains(help, "/join [channel] [password]") ||
+		!strings.Contains(help, "/deploy [service]") {
+		t.Errorf("host commands missing from help %q", help)
+	}
+	tel.helpCommand("/deploy")
+	if help := tel.buffers["testchannel"][0].msg.Body; help !=
+		"/deploy [service]: shows deploy status" {
+		t.Errorf("incorrect command help %q", help)
+	}
+
+	if isCommand, _ := tel.parseCommand([]byte("/deploy api\r\n")); !isCommand ||
+		len(host.commands) != 1 || host.commands[0][0] != "api\r\n" {
+		t.Errorf("command not handed to host %v", host.commands)
+	}
+	tel.parseCommand([]byte("/deploy"))
+	if body := tel.buffers["testchannel"][0].msg.Body; body != "usage: /deploy [service]" {
+		t.Errorf("host error not shown %q", body)
+	}
+
+	// built in commands that aren't the session's own are the host's too
+	if isCommand, _ := tel.parseCommand([]byte("/who\r\n")); !isCommand ||
+		host.ran[len(host.ran)-1] != "/who" {
+		t.Errorf("/who not handed to host %v", host.ran)
+	}
+
+	// nobody knows it so it's just a message
+	if isCommand, _ := tel.parseCommand([]byte("/shrug\r\n")); isCommand {
+		t.Errorf("unknown command swallowed")
+	}
+}
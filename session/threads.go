@@ -0,0 +1,103 @@
+package session
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// how much of the message being replied to is quoted
+	QUOTE_LENGTH = 30
+)
+
+var (
+	replyHelp  = "usage: /reply [message id] [message]"
+	threadHelp = "usage: /thread [message id]"
+)
+
+// turns "/reply 42 sounds good" into a message replying to message 42
+func (s *Telnet) parseReply(input []byte) (reply Message, ok bool) {
+	fields := strings.SplitN(strings.TrimSpace(string(input)), " ", 3)
+	if len(fields) < 3 || fields[0] != "/reply" {
+		return reply, false
+	}
+	parent, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil || parent == 0 || strings.TrimSpace(fields[2]) == "" {
+		return reply, false
+	}
+
+	reply = s.newMessage([]byte(fields[2]))
+	reply.ReplyTo = parent
+	return reply, true
+}
+
+// /me and /reply send messages rather than being handled as commands
+func (s *Telnet) parseMessageCommand(input []byte) (msg Message, ok bool) {
+	if msg, ok = s.parseAction(input); ok {
+		return msg, ok
+	}
+	return s.parseReply(input)
+}
+
+// finds a message in a channel's buffer, expects bufferMtx to be held
+func (s *Telnet) findMessage(channel string, id uint64) (msg Message, ok bool) {
+	for _, entry := range s.buffers[channel] {
+		if !entry.event && entry.msg.ID == id {
+			return entry.msg, true
+		}
+	}
+	return msg, false
+}
+
+// short quote of the message being replied to, just the id if it's no longer
+// in the buffer. expects bufferMtx to be held
+func (s *Telnet) quoteParent(msg Message) string {
+	quote := "re " + strconv.FormatUint(msg.ReplyTo, 10)
+	parent, ok := s.findMessage(msg.Channel, msg.ReplyTo)
+	if !ok {
+		return "(" + quote + ")"
+	}
+
+	body := displayBody(parent.Body)
+	if parent.Deleted {
+		body = "(message deleted)"
+	}
+	if len(body) > QUOTE_LENGTH {
+		body = body[:QUOTE_LENGTH-3] + "..."
+	}
+	return "(" + quote + " " + parent.From.Username() + ": " + body + ")"
+}
+
+// handles /thread [message id], showing a message and every reply to it
+func (s *Telnet) threadCommand(arg string) (err error) {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || id == 0 {
+		return s.SendEvent(s.newMessage([]byte(threadHelp)))
+	}
+
+	thread, err := s.host.Thread(s, id)
+	if err != nil {
+		return s.SendEvent(s.newMessage([]byte("unable to show thread: " +
+			err.Error())))
+	}
+
+	for i, msg := range thread {
+		line := ""
+		if i > 0 {
+			line = "  -> "
+		}
+		line += "[" + s.timestamp(msg.T) + "|" +
+			strconv.FormatUint(msg.ID, 10) + "] " + msg.From.Username() + ": "
+		if msg.Deleted {
+			line += "(message deleted)"
+		} else {
+			line += displayBody(msg.Body)
+		}
+
+		err = s.SendEvent(s.newMessage([]byte(line)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
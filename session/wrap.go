@@ -0,0 +1,142 @@
+package session
+
+import "strings"
+
+// returns the length of the escape sequence starting at s[i], or 0 if there
+// isn't one. handles CSI (\033[...) and OSC (\033]...) sequences which is all
+// we ever write ourselves
+func escapeLen(s string, i int) int {
+	if s[i] != '\033' || i+1 >= len(s) {
+		return 0
+	}
+
+	switch s[i+1] {
+	case '[':
+		// CSI sequences end with a byte in the @ to ~ range
+		for j := i + 2; j < len(s); j++ {
+			if s[j] >= '@' && s[j] <= '~' {
+				return j - i + 1
+			}
+		}
+	case ']':
+		// OSC sequences end with BEL or ST (\033\)
+		for j := i + 2; j < len(s); j++ {
+			if s[j] == '\007' {
+				return j - i + 1
+			}
+			if s[j] == '\033' && j+1 < len(s) && s[j+1] == '\\' {
+				return j - i + 2
+			}
+		}
+	default:
+		// two byte sequences like ESC 7
+		return 2
+	}
+
+	// unterminated, treat the rest of the string as part of the sequence
+	return len(s) - i
+}
+
+// removes escape sequences from a string, for clients that can't render them
+func stripEscapes(s string) string {
+	var stripped strings.Builder
+	for i := 0; i < len(s); i++ {
+		if n := escapeLen(s, i); n > 0 {
+			i += n - 1
+			continue
+		}
+		stripped.WriteByte(s[i])
+	}
+	return stripped.String()
+}
+
+// number of columns a string takes up on screen, ignoring escape sequences
+func visibleWidth(s string) int {
+	width := 0
+	for i := 0; i < len(s); i++ {
+		if n := escapeLen(s, i); n > 0 {
+			i += n - 1
+			continue
+		}
+		width++
+	}
+	return width
+}
+
+// breaks a rendered line into rows no wider than width, preferring to break
+// on spaces. rows after the first are indented by indent columns so wrapped
+// text hangs under the message body instead of the username. color sequences
+// are carried over to continuation rows since rows aren't drawn in order
+func wrapLine(line string, width, indent int) []string {
+	if width <= 0 || visibleWidth(line) <= width {
+		return []string{line}
+	}
+
+	// a hanging indent that leaves no room for text isn't worth having
+	if width-indent < width/3 {
+		indent = 0
+	}
+
+	var rows []string
+	var row strings.Builder
+	col := 0
+	rowStart := 0
+	color := ""
+
+	newRow := func() {
+		rows = append(rows, strings.TrimRight(row.String(), " "))
+		row.Reset()
+		row.WriteString(strings.Repeat(" ", indent) + color)
+		col = indent
+		rowStart = indent
+	}
+
+	for i := 0; i < len(line); {
+		if n := escapeLen(line, i); n > 0 {
+			seq := line[i : i+n]
+			row.WriteString(seq)
+			// only color/attribute changes need carrying over
+			if strings.HasSuffix(seq, "m") {
+				color = seq
+			}
+			i += n
+			continue
+		}
+
+		// gather up the next run of spaces or non-spaces
+		j := i
+		isSpace := line[i] == ' '
+		for j < len(line) && (line[j] == ' ') == isSpace && escapeLen(line, j) == 0 {
+			j++
+		}
+		token := line[i:j]
+		i = j
+
+		if isSpace {
+			// spaces at a break are swallowed rather than starting the
+			// next row
+			if col+len(token) > width {
+				newRow()
+				continue
+			}
+			row.WriteString(token)
+			col += len(token)
+			continue
+		}
+
+		// move words that don't fit to the next row, unless the row is
+		// empty in which case the word has to be split anyway
+		if col+len(token) > width && col > rowStart {
+			newRow()
+		}
+		for col+len(token) > width {
+			cut := width - col
+			row.WriteString(token[:cut])
+			token = token[cut:]
+			newRow()
+		}
+		row.WriteString(token)
+		col += len(token)
+	}
+	return append(rows, row.String())
+}
@@ -0,0 +1,35 @@
+package session
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVisibleWidthIgnoresEscapes(t *testing.T) {
+	line := EVENT_COLOR + "[12:00:00] " + MESSAGE_COLOR + "hi"
+	if w := visibleWidth(line); w != 13 {
+		t.Errorf("incorrect visible width %d", w)
+	}
+}
+
+func TestWrapLineBreaksOnSpaces(t *testing.T) {
+	rows := wrapLine("user: the quick brown fox", 14, 6)
+	expected := []string{"user: the", "      quick", "      brown", "      fox"}
+	if strings.Join(rows, "|") != strings.Join(expected, "|") {
+		t.Errorf("incorrect wrapping %q", rows)
+	}
+}
+
+func TestWrapLineSplitsLongWords(t *testing.T) {
+	rows := wrapLine("abcdefghij", 4, 0)
+	if len(rows) != 3 || rows[2] != "ij" {
+		t.Errorf("long word not split %q", rows)
+	}
+}
+
+func TestWrapLineCarriesColor(t *testing.T) {
+	rows := wrapLine(MESSAGE_COLOR+"aaaa bbbb", 4, 0)
+	if len(rows) != 2 || rows[1] != MESSAGE_COLOR+"bbbb" {
+		t.Errorf("color not carried to continuation row %q", rows)
+	}
+}
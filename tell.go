@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+// leaves a message for a registered user to get when they next log in,
+// people who are online just get it as a direct message
+func (s *Server) Tell(sesh session.Session, username,
+	body string) (delivered bool, err error) {
+	if len(strings.TrimSpace(body)) < s.minimumMessageSize {
+		return false, errors.New("message too short")
+	}
+
+	s.sessionLock.Lock()
+	_, online := s.sessions[username]
+	s.sessionLock.Unlock()
+	if online {
+		return true, s.DirectMessage(sesh, username, body)
+	}
+
+	if !s.accounts.Registered(username) {
+		return false, errors.New(username + " isn't registered, messages " +
+			"are only kept for registered users")
+	}
+
+	// same as direct messages, ignored senders are quietly dropped
+	for _, mask := range s.accounts.Ignores(username) {
+		if maskMatches(mask, sesh.Username(), sesh.RemoteAddr()) {
+			return false, nil
+		}
+	}
+
+	return false, s.accounts.QueueTell(username, Tell{From: sesh.Username(),
+		Body: body, Sent: time.Now()})
+}
+
+// hands a newly logged in session whatever was left for them, letting the
+// senders know if they're around
+func (s *Server) deliverTells(sesh session.Session) {
+	tells, err := s.accounts.TakeTells(sesh.Username())
+	if err != nil {
+		log.Printf("Unable to save delivered messages for %s %v\n",
+			sesh.Username(), err)
+	}
+
+	for _, tell := range tells {
+		err = sesh.SendEvent(systemEvent(tell.From+" left you a message "+
+			tell.Sent.Format("2006-01-02 15:04")+": "+tell.Body, sesh.Channel(),
+			sesh))
+		if err != nil {
+			// they'll get them again next time
+			s.accounts.QueueTell(sesh.Username(), tell)
+			continue
+		}
+
+		s.sessionLock.Lock()
+		sender, ok := s.sessions[tell.From]
+		s.sessionLock.Unlock()
+		if ok {
+			sender.SendEvent(systemEvent(sesh.Username()+
+				" got your message from "+tell.Sent.Format("2006-01-02 15:04"),
+				sender.Channel(), sesh))
+		}
+	}
+}
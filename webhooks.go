@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+const (
+	// deliveries that keep failing are given up on after this many tries
+	WEBHOOK_MAX_ATTEMPTS = 8
+	// wait before the first retry, doubling each time after up to the max
+	WEBHOOK_MIN_BACKOFF = time.Second
+	WEBHOOK_MAX_BACKOFF = 10 * time.Minute
+	// how long a single delivery can take
+	WEBHOOK_TIMEOUT = 10 * time.Second
+
+	// headers sent along with every delivery. the signature is a hex sha256
+	// hmac of the body using the webhook's secret, like sha256=ab12...
+	WEBHOOK_SIGNATURE_HEADER = "X-Wally-Signature"
+	WEBHOOK_DELIVERY_HEADER  = "X-Wally-Delivery"
+)
+
+// somewhere channel activity is posted to, configured in webhooks_file
+type Webhook struct {
+	// unique, deliveries waiting in the queue refer to webhooks by name
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// only activity in this channel, empty for every channel that isn't
+	// private or secret. those are only posted when named here
+	Channel string `json:"channel,omitempty"`
+	// only messages containing one of these, empty for every message
+	Keywords []string `json:"keywords,omitempty"`
+	// events (joins, kicks, topic changes) are posted as well as messages
+	Events bool `json:"events,omitempty"`
+	// used to sign deliveries so receivers know they came from us
+	Secret string `json:"secret,omitempty"`
+}
+
+// whether a message or event should be posted to the webhook, hidden is
+// whether the channel is private or secret
+func (w Webhook) Matches(msg session.Message, event, hidden bool) bool {
+	if event && !w.Events {
+		return false
+	}
+	if w.Channel != "" && w.Channel != msg.Channel {
+		return false
+	}
+	if w.Channel == "" && hidden {
+		return false
+	}
+	if len(w.Keywords) == 0 || event {
+		return true
+	}
+	body := strings.ToLower(msg.Body)
+	for _, keyword := range w.Keywords {
+		if strings.Contains(body, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// what gets posted to webhooks
+type WebhookPayload struct {
+	// "message" or "event"
+	Type    string          `json:"type"`
+	Webhook string          `json:"webhook"`
+	From    string          `json:"from"`
+	Message session.Message `json:"message"`
+}
+
+// a payload waiting to be posted, kept on disk until it has been
+type webhookDelivery struct {
+	ID          uint64          `json:"id"`
+	Webhook     string          `json:"webhook"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+}
+
+// how long to wait before trying a delivery again
+func webhookBackoff(attempts int) time.Duration {
+	backoff := WEBHOOK_MIN_BACKOFF
+	for i := 1; i < attempts && backoff < WEBHOOK_MAX_BACKOFF; i++ {
+		backoff *= 2
+	}
+	if backoff > WEBHOOK_MAX_BACKOFF {
+		return WEBHOOK_MAX_BACKOFF
+	}
+	return backoff
+}
+
+// signature of body for the signature header
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// posts channel activity to webhooks. deliveries are queued on disk and
+// retried with backoff so nothing is lost when a receiver or the server is
+// down for a while
+type WebhookDispatcher struct {
+	hooks  map[string]Webhook
+	queue  []webhookDelivery
+	lastID uint64
+	mtx    sync.Mutex
+
+	// where the queue is saved, empty keeps it in memory only. Run saves it
+	// when it's dirty so broadcasting never waits on the disk
+	path   string
+	dirty  bool
+	client *http.Client
+	// pokes Run when there's something new to deliver
+	wake chan struct{}
+}
+
+// dispatcher creation helper method
+func NewWebhookDispatcher() *WebhookDispatcher {
+	return &WebhookDispatcher{hooks: make(map[string]Webhook),
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+		wake:   make(chan struct{}, 1)}
+}
+
+// loads webhooks from hooksPath and the delivery queue from queuePath, the
+// queue is saved there from now on
+func (d *WebhookDispatcher) Load(hooksPath, queuePath string) error {
+	var hooks []Webhook
+	err := loadJSON(hooksPath, &hooks)
+	if err != nil {
+		return err
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, hook := range hooks {
+		if hook.Name == "" || hook.URL == "" {
+			return errors.New("webhooks need a name and url")
+		}
+		if _, ok := d.hooks[hook.Name]; ok {
+			return errors.New("more than one webhook named " + hook.Name)
+		}
+		d.hooks[hook.Name] = hook
+	}
+
+	err = loadJSON(queuePath, &d.queue)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range d.queue {
+		if delivery.ID > d.lastID {
+			d.lastID = delivery.ID
+		}
+	}
+	d.path = queuePath
+	return nil
+}
+
+// writes the queue to disk if it has changed since it was last saved. only
+// one goroutine should call this at a time
+func (d *WebhookDispatcher) save() {
+	d.mtx.Lock()
+	if !d.dirty || d.path == "" {
+		d.mtx.Unlock()
+		return
+	}
+	queue := append([]webhookDelivery(nil), d.queue...)
+	d.dirty = false
+	d.mtx.Unlock()
+
+	err := saveJSON(d.path, queue)
+	if err != nil {
+		log.Printf("Unable to save webhook queue %v\n", err)
+		d.mtx.Lock()
+		d.dirty = true
+		d.mtx.Unlock()
+	}
+}
+
+// queues a message or event for every webhook it matches, hidden is whether
+// the channel is private or secret
+func (d *WebhookDispatcher) Notify(msg session.Message, event, hidden bool) {
+	payloadType := "message"
+	if event {
+		payloadType = "event"
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	queued := false
+	for name, hook := range d.hooks {
+		if !hook.Matches(msg, event, hidden) {
+			continue
+		}
+		payload, err := json.Marshal(WebhookPayload{Type: payloadType,
+			Webhook: name, From: msg.From.Username(), Message: msg})
+		if err != nil {
+			log.Printf("Unable to encode webhook payload %v\n", err)
+			continue
+		}
+
+		d.lastID++
+		d.queue = append(d.queue, webhookDelivery{ID: d.lastID,
+			Webhook: name, Payload: payload, NextAttempt: time.Now()})
+		queued = true
+	}
+	if !queued {
+		return
+	}
+
+	d.dirty = true
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// posts a single delivery, retry says whether it's worth trying again
+func (d *WebhookDispatcher) post(hook Webhook,
+	delivery webhookDelivery) (retry bool, err error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_DELIVERY_HEADER, strconv.FormatUint(delivery.ID, 10))
+	if hook.Secret != "" {
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, signWebhook(hook.Secret,
+			delivery.Payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, errors.New(resp.Status)
+	default:
+		// the receiver doesn't want it, sending it again won't help
+		return false, errors.New(resp.Status)
+	}
+}
+
+// attempts every delivery due by now, returning when the next one is due
+// (zero if the queue is empty). only one goroutine should call this at a
+// time
+func (d *WebhookDispatcher) deliverDue(now time.Time) (next time.Time) {
+	// whatever was queued since last time is on disk before we try it
+	d.save()
+	defer d.save()
+
+	d.mtx.Lock()
+	var due []webhookDelivery
+	for _, delivery := range d.queue {
+		if !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	hooks := d.hooks
+	d.mtx.Unlock()
+
+	// send without holding the lock so new activity can still be queued
+	attempted := make(map[uint64]bool)
+	done := make(map[uint64]bool)
+	for _, delivery := range due {
+		attempted[delivery.ID] = true
+		hook, ok := hooks[delivery.Webhook]
+		if !ok {
+			// removed from the config since it was queued
+			done[delivery.ID] = true
+			continue
+		}
+
+		retry, err := d.post(hook, delivery)
+		if err == nil {
+			done[delivery.ID] = true
+			continue
+		}
+		if !retry || delivery.Attempts+1 >= WEBHOOK_MAX_ATTEMPTS {
+			log.Printf("Giving up on webhook %s delivery %d %v\n", hook.Name,
+				delivery.ID, err)
+			done[delivery.ID] = true
+			continue
+		}
+		log.Printf("Webhook %s delivery %d failed, will retry %v\n", hook.Name,
+			delivery.ID, err)
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	queue := d.queue[:0]
+	for _, delivery := range d.queue {
+		if done[delivery.ID] {
+			continue
+		}
+		if attempted[delivery.ID] {
+			delivery.Attempts++
+			delivery.NextAttempt = now.Add(webhookBackoff(delivery.Attempts))
+		}
+		if next.IsZero() || delivery.NextAttempt.Before(next) {
+			next = delivery.NextAttempt
+		}
+		queue = append(queue, delivery)
+	}
+	d.queue = queue
+	if len(due) > 0 {
+		d.dirty = true
+	}
+	return next
+}
+
+// delivers queued payloads as they come due, forever
+func (d *WebhookDispatcher) Run() {
+	for {
+		next := d.deliverDue(time.Now())
+
+		// nothing queued means nothing to do until Notify wakes us
+		var timer <-chan time.Time
+		if !next.IsZero() {
+			timer = time.After(time.Until(next))
+		}
+		select {
+		case <-d.wake:
+		case <-timer:
+		}
+	}
+}
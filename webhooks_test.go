@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/taterbase/wally-chat/session"
+)
+
+func TestWebhookMatches(t *testing.T) {
+	sesh := createMockSession("dan")
+	hook := Webhook{Channel: "ops", Keywords: []string{"Deploy"}}
+	if !hook.Matches(session.NewMessage("deploying now", "ops", sesh), false, false) {
+		t.Errorf("keyword not matched")
+	}
+	if hook.Matches(session.NewMessage("lunch", "ops", sesh), false, false) {
+		t.Errorf("message without keyword matched")
+	}
+	if hook.Matches(session.NewMessage("deploy", "general", sesh), false, false) {
+		t.Errorf("other channel matched")
+	}
+	if hook.Matches(session.NewMessage("dan joined", "ops", sesh), true, false) {
+		t.Errorf("event matched without events on")
+	}
+
+	// private and secret channels have to be asked for by name
+	if !hook.Matches(session.NewMessage("deploy", "ops", sesh), false, true) {
+		t.Errorf("named private channel not matched")
+	}
+	everything := Webhook{}
+	if everything.Matches(session.NewMessage("deploy", "ops", sesh), false, true) {
+		t.Errorf("private channel matched without being named")
+	}
+}
+
+func TestWebhooksSkipPrivateChannels(t *testing.T) {
+	_, _, s := createMocks()
+	s.webhooks.hooks["everything"] = Webhook{Name: "everything", URL: "http://x"}
+	op := createMockSession("op")
+	op.extraChannels = []string{"ops"}
+	s.JoinChannel(op, "ops", "hunter2")
+
+	s.broadcast(session.NewMessage("top secret", "ops", op), MESSAGE)
+	if len(s.webhooks.queue) != 0 {
+		t.Errorf("private channel posted %v", s.webhooks.queue)
+	}
+	s.broadcast(session.NewMessage("hello", testChannel, op), MESSAGE)
+	if len(s.webhooks.queue) != 1 {
+		t.Errorf("public channel not posted %v", s.webhooks.queue)
+	}
+}
+
+func TestWebhooksPostBotMessages(t *testing.T) {
+	d := NewWebhookDispatcher()
+	d.hooks["tools"] = Webhook{Name: "tools", URL: "http://x"}
+	bot := session.NewBot("standup", "", testChannel)
+	bot.OnMessage = func(*session.Bot, session.Message) {}
+
+	d.Notify(session.NewMessage("standup time", testChannel, bot), false, false)
+	if len(d.queue) != 1 {
+		t.Fatalf("bot message not queued")
+	}
+	var payload struct {
+		From    string
+		Message struct {
+			From struct {
+				Username string
+			} `json:"session"`
+		}
+	}
+	if err := json.Unmarshal(d.queue[0].Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.From != "standup" || payload.Message.From.Username != "standup" {
+		t.Errorf("incorrect payload %s", d.queue[0].Payload)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	var received []*http.Request
+	var payloads [][]byte
+	failures := 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r)
+		payloads = append(payloads, body)
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hooksPath := filepath.Join(dir, "webhooks.json")
+	queuePath := filepath.Join(dir, "queue.json")
+	err = saveJSON(hooksPath, []Webhook{{Name: "tools", URL: ts.URL,
+		Secret: "shh"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewWebhookDispatcher()
+	if err := d.Load(hooksPath, queuePath); err != nil {
+		t.Fatal(err)
+	}
+	d.Notify(session.NewMessage("hello", testChannel, createMockSession("dan")),
+		false, false)
+	if _, err := os.Stat(queuePath); !os.IsNotExist(err) {
+		t.Errorf("queue saved while broadcasting %v", err)
+	}
+	// Run saves the queue before delivering
+	d.save()
+
+	// queued deliveries survive a restart
+	restarted := NewWebhookDispatcher()
+	if err := restarted.Load(hooksPath, queuePath); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	next := restarted.deliverDue(now)
+	if len(received) != 1 || next.Sub(now) != WEBHOOK_MIN_BACKOFF {
+		t.Fatalf("failed delivery not retried with backoff %d %v", len(received),
+			next.Sub(now))
+	}
+	if restarted.deliverDue(now); len(received) != 1 {
+		t.Errorf("retried before backoff")
+	}
+	if next := restarted.deliverDue(next); len(received) != 2 || !next.IsZero() {
+		t.Errorf("delivery not retried %d", len(received))
+	}
+
+	r := received[1]
+	if r.Header.Get(WEBHOOK_SIGNATURE_HEADER) != signWebhook("shh", payloads[1]) {
+		t.Errorf("incorrect signature %s", r.Header.Get(WEBHOOK_SIGNATURE_HEADER))
+	}
+	// receivers see the session as plain json
+	var payload struct {
+		Type    string
+		From    string
+		Message struct {
+			Body string
+		}
+	}
+	if err := json.Unmarshal(payloads[1], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Type != "message" || payload.From != "dan" ||
+		payload.Message.Body != "hello" {
+		t.Errorf("incorrect payload %s", payloads[1])
+	}
+
+	var queue []webhookDelivery
+	loadJSON(queuePath, &queue)
+	if len(queue) != 0 {
+		t.Errorf("delivered payload left in queue %v", queue)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	if webhookBackoff(1) != WEBHOOK_MIN_BACKOFF ||
+		webhookBackoff(3) != 4*WEBHOOK_MIN_BACKOFF ||
+		webhookBackoff(WEBHOOK_MAX_ATTEMPTS*10) != WEBHOOK_MAX_BACKOFF {
+		t.Errorf("incorrect backoff")
+	}
+}